@@ -9,7 +9,8 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart) (err error) {
+// InstallApplications installs the DIMO applications, priorityClasses are the tiers their pods are scheduled with
+func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
 	// Use this later to configure sets of applications to install
 	applications := []string{
 		//"users-api",
@@ -33,7 +34,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	}
 
 	if slices.Contains(applications, "kube-prometheus-stack") {
-		err = InstallKubePrometheus(ctx, kubeProvider, namespaceMap["monitoring"])
+		err = InstallKubePrometheus(ctx, kubeProvider, namespaceMap["monitoring"], nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -51,7 +52,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	// Chart Link [ ]
 	//
 	if slices.Contains(applications, "users-api") {
-		err = InstallUsersApi(ctx, kubeProvider)
+		err = InstallUsersApi(ctx, kubeProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -59,7 +60,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 
 	// Identity API
 	if slices.Contains(applications, "identity-api") {
-		err = InstallIdentityApi(ctx, kubeProvider, SecretsProvider)
+		err = InstallIdentityApi(ctx, kubeProvider, SecretsProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -67,7 +68,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 
 	// Device Data API
 	if slices.Contains(applications, "device-data-api") {
-		err = InstallDeviceDataApi(ctx, kubeProvider, SecretsProvider)
+		err = InstallDeviceDataApi(ctx, kubeProvider, SecretsProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -75,7 +76,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 
 	// Contract Event Processor
 	if slices.Contains(applications, "contract-event-processor") {
-		err = InstallContractEventProcessor(ctx, kubeProvider)
+		err = InstallContractEventProcessor(ctx, kubeProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -86,7 +87,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	// Pull most everything in
 	// Will need to set up ingress
 	if slices.Contains(applications, "mqtt-broker") {
-		err = InstallMQTTBroker(ctx, kubeProvider)
+		err = InstallMQTTBroker(ctx, kubeProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -100,7 +101,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	// issuer is just URL config (issued by)
 	// Create the dex-X-secret (dont include environment from and don't create)
 	if slices.Contains(applications, "dex-auth-n") {
-		err = InstallDexAuthN(ctx, kubeProvider, SecretsProvider)
+		err = InstallDexAuthN(ctx, kubeProvider, SecretsProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -111,7 +112,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	// Not exposed publicly
 	// Connector may not be necessary (even though it says it is lol)
 	if slices.Contains(applications, "dex-auth-z") {
-		err = InstallDexAuthZ(ctx, kubeProvider, SecretsProvider)
+		err = InstallDexAuthZ(ctx, kubeProvider, SecretsProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -129,7 +130,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	// Token Base Uri is used in the certificateResponseData
 	// Already configured with chain_id 137 (polygon)
 	if slices.Contains(applications, "webhook-validator") {
-		err = InstallWebhookValidator(ctx, kubeProvider)
+		err = InstallWebhookValidator(ctx, kubeProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -139,7 +140,7 @@ func InstallApplications(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	// Before needed to manually create KMS keys (generate) - There is a GIST for this but now is a CLI
 	//   ^ for aws, need to figure out GCP
 	if slices.Contains(applications, "certificate-authority") {
-		err = InstallCertificateAuthority(ctx, kubeProvider)
+		err = InstallCertificateAuthority(ctx, kubeProvider, nodeConfig, priorityClasses)
 		if err != nil {
 			return err
 		}
@@ -1,20 +1,23 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 	//"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func InstallCertificateAuthority(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (err error) {
+func InstallCertificateAuthority(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
 	//conf := config.New(ctx, "")
 	//environmentName := conf.Require("environment")
 	//Deploy the users-api from helm chart
 	usersApi, err := helm.NewChart(ctx, "certificate-authority", helm.ChartArgs{
-		Chart:     pulumi.String("dimo-ca"),
-		Path:      pulumi.String("./applications/cluster-helm-charts/charts"),
-		Namespace: pulumi.String("certificate-authority"),
+		Chart:           pulumi.String("dimo-ca"),
+		Path:            pulumi.String("./applications/cluster-helm-charts/charts"),
+		Namespace:       pulumi.String("certificate-authority"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCore)},
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"), // We need to push public versions of the images to docker.io
@@ -29,7 +32,7 @@ func InstallCertificateAuthority(ctx *pulumi.Context, kubeProvider *kubernetes.P
 				"enabled": pulumi.Bool(true),
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("certificate-authority.dimo.zone"), // TODO: Get host from cloud provider
+						"host": pulumi.String(nodeConfig.Host("certificate-authority")),
 						// Find how to only pass in identity-api and get the service from the environment
 						"paths": pulumi.Array{
 							pulumi.Map{
@@ -44,10 +47,10 @@ func InstallCertificateAuthority(ctx *pulumi.Context, kubeProvider *kubernetes.P
 			// Could use mumbai testnet contracts also in the short term
 			// Define sets of addresses at the top level for different environments
 			"env": pulumi.Map{
-				"BASE_IMAGE_URL": pulumi.String("https://certificate-authority.dimo.zone/v1"),
+				"BASE_IMAGE_URL": pulumi.String("https://" + nodeConfig.Host("certificate-authority") + "/v1"),
 			},
 		},
-	}, pulumi.Provider(kubeProvider),
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses),
 		pulumi.Transformations([]pulumi.ResourceTransformation{
 			func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
 				if args.Type == "kubernetes:admissionregistration.k8s.io/v1:ValidatingWebhookConfiguration" ||
@@ -1,24 +1,25 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
 // Work with the values-prod.yaml file to get the correct values for the environment (for now)
 // The configmap helm stuff globs the files in the directory and creates a configmap from them
 
-func InstallContractEventProcessor(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (err error) {
-	conf := config.New(ctx, "")
-	environmentName := conf.Require("environment")
+func InstallContractEventProcessor(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
+	environmentName := nodeConfig.Environment
 
 	//Deploy the users-api from helm chart
 	usersApi, err := helm.NewChart(ctx, "contract-event-processor", helm.ChartArgs{
-		Chart:     pulumi.String("contract-event-processor"),
-		Path:      pulumi.String("./applications/contract-event-processor/charts"),
-		Namespace: pulumi.String("contract-event-processor"),
+		Chart:           pulumi.String("contract-event-processor"),
+		Path:            pulumi.String("./applications/contract-event-processor/charts"),
+		Namespace:       pulumi.String("contract-event-processor"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCore)},
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"), // We need to push public versions of the images to docker.io
@@ -38,7 +39,7 @@ func InstallContractEventProcessor(ctx *pulumi.Context, kubeProvider *kubernetes
 				"BLOCK_CONFIRMATIONS": pulumi.Int(5),
 			},
 		},
-	}, pulumi.Provider(kubeProvider))
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses))
 	if err != nil {
 		return err
 	}
@@ -1,58 +1,33 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
-	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
-	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func InstallDeviceDataApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart) (err error) {
-	conf := config.New(ctx, "")
-	environmentName := conf.Require("environment")
-
-	_, err = apiextensions.NewCustomResource(ctx, "external-secret-device-data-api", &apiextensions.CustomResourceArgs{
-		ApiVersion: pulumi.String("external-secrets.io/v1beta1"),
-		Kind:       pulumi.String("ExternalSecret"),
-		Metadata: &metav1.ObjectMetaArgs{
-			Name:      pulumi.String("device-data-api-secret"),
-			Namespace: pulumi.String("device-data"),
-		},
-		OtherFields: map[string]any{
-			"spec": map[string]any{
-				"secretStoreRef": map[string]any{
-					"name": pulumi.String("cluster-secret-store"),
-				},
-				"target": map[string]any{
-					"name": pulumi.String("device-data-api-secret"),
-				},
-				"data": pulumi.Array{
-					pulumi.Map{
-						"secretKey": pulumi.String("secret"),
-						"remoteRef": pulumi.Map{
-							"key": pulumi.String("device-data-api-secret"),
-						},
-					},
-				},
-			},
-		},
-	}, pulumi.Provider(kubeProvider),
-		pulumi.IgnoreChanges([]string{
-			"spec.data",
-			"spec.secretStoreRef.name",
-		}))
+func InstallDeviceDataApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
+	environmentName := nodeConfig.Environment
 
+	// The chart waits for the secret to sync, so the pods don't start without it
+	deviceDataSecret, err := utils.NewExternalSecret(ctx, "external-secret-device-data-api", kubeProvider, utils.ExternalSecretArgs{
+		Namespace:  "device-data",
+		SecretName: "device-data-api-secret",
+		Data:       map[string]string{"secret": utils.DeviceDataAPISecretID},
+		DependsOn:  []pulumi.Resource{SecretsProvider},
+	})
 	if err != nil {
 		return err
 	}
 
 	//Deploy the users-api from helm chart
 	usersApi, err := helm.NewChart(ctx, "device-data-api", helm.ChartArgs{
-		Chart:     pulumi.String("device-data-api"),
-		Path:      pulumi.String("./applications/device-data-api/charts"),
-		Namespace: pulumi.String("device-data"),
+		Chart:           pulumi.String("device-data-api"),
+		Path:            pulumi.String("./applications/device-data-api/charts"),
+		Namespace:       pulumi.String("device-data"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCore)},
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"), // We need to push public versions of the images to docker.io
@@ -71,11 +46,10 @@ func InstallDeviceDataApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider
 					"nginx.ingress.kubernetes.io/enable-cors":            pulumi.String("true"),
 					"nginx.ingress.kubernetes.io/cors-allow-origin":      pulumi.String("https://app.dimo.zone"),
 					"nginx.ingress.kubernetes.io/limit-rps":              pulumi.String("9"),
-					"external-dns.alpha.kubernetes.io/hostname":          pulumi.String("device-data-api.dimo.zone"),
 				},
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("device-data-api.dimo.zone"), // TODO: Get host from cloud provider
+						"host": pulumi.String(nodeConfig.Host("device-data-api")),
 						"paths": pulumi.Array{
 							pulumi.Map{
 								"path":     pulumi.String("/"),
@@ -91,7 +65,7 @@ func InstallDeviceDataApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider
 				"LOG_LEVEL":                      pulumi.String("info"),
 				"SERVICE_NAME":                   pulumi.String("device-data-api"),             // ?
 				"JWT_KEY_SET_URL":                pulumi.String("https://auth.dimo.zone/keys"), // Comes from DEX
-				"DEPLOYMENT_BASE_URL":            pulumi.String("https://device-data-api.dimo.zone"),
+				"DEPLOYMENT_BASE_URL":            pulumi.String("https://" + nodeConfig.Host("device-data-api")),
 				"DEVICE_DATA_INDEX_NAME":         pulumi.String("device-status-prod*"),
 				"DEVICE_DATA_INDEX_NAME_V2":      pulumi.String("vss-status-prod*"),
 				"DEVICES_APIGRPC_ADDR":           pulumi.String("devices-api-prod:8086"),
@@ -115,7 +89,8 @@ func InstallDeviceDataApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider
 				},
 			},
 		},
-	}, pulumi.Provider(kubeProvider))
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses),
+		utils.DependsOnSecrets(deviceDataSecret))
 	if err != nil {
 		return err
 	}
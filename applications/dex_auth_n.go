@@ -1,14 +1,13 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
-	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
-	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallDexAuthN(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart) (err error) {
+func InstallDexAuthN(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
 	//environmentName := conf.Require("environment")
 
 	/*
@@ -49,37 +48,14 @@ func InstallDexAuthN(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, Sec
 
 	// Create a secret for the dex-auth-n called dex-apple-auth-secret
 
-	// Do it with the external secrets provider crd instead of through google?
-	_, err = apiextensions.NewCustomResource(ctx, "external-secret-dex-auth-n", &apiextensions.CustomResourceArgs{
-		ApiVersion: pulumi.String("external-secrets.io/v1beta1"),
-		Kind:       pulumi.String("ExternalSecret"),
-		Metadata: &metav1.ObjectMetaArgs{
-			Name:      pulumi.String("dex-apple-auth-secret"),
-			Namespace: pulumi.String("dex"),
-			Annotations: pulumi.StringMap{
-				"app.kubernetes.io/managed-by": pulumi.String("Helm"),
-			},
-		},
-		OtherFields: map[string]any{
-			"spec": map[string]any{
-				"secretStoreRef": map[string]any{
-					"name": pulumi.String("cluster-secret-store"),
-				},
-				"target": map[string]any{
-					"name": pulumi.String("daas-secret"),
-				},
-				"data": pulumi.Array{
-					pulumi.Map{
-						"secretKey": pulumi.String("secret"),
-						"remoteRef": pulumi.Map{
-							"key": pulumi.String("daas-secret"),
-						},
-					},
-				},
-			},
-		},
-	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{SecretsProvider}))
-
+	// The release waits for the secret to sync, so the pods don't start without it
+	dexSecret, err := utils.NewExternalSecret(ctx, "external-secret-dex-auth-n", kubeProvider, utils.ExternalSecretArgs{
+		Name:       "dex-apple-auth-secret",
+		Namespace:  "dex",
+		SecretName: "daas-secret",
+		Data:       map[string]string{"secret": utils.DexAuthNSecretID},
+		DependsOn:  []pulumi.Resource{SecretsProvider},
+	})
 	if err != nil {
 		return err
 	}
@@ -155,12 +131,14 @@ func InstallDexAuthN(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, Sec
 			pulumi.NewFileAsset("./applications/cluster-helm-charts/charts/dimo-dex/values-prod.yaml"),
 		},
 		Namespace: pulumi.String("dex"),
+		// Releases are rendered by helm, so the tier is applied by the post-renderer in cmd/priority-tier
+		Postrender: pulumi.String("priority-tier"),
 		Values: pulumi.Map{
 			"ingress": pulumi.Map{
 				"enabled": pulumi.Bool(true),
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("dex-auth-n.dimo.zone"), // TODO: Get host from cloud provider
+						"host": pulumi.String(nodeConfig.Host("dex-auth-n")),
 						// Find how to only pass in identity-api and get the service from the environment
 						"paths": pulumi.Array{
 							pulumi.Map{
@@ -172,10 +150,11 @@ func InstallDexAuthN(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, Sec
 				},
 			},
 			"env": pulumi.Map{
-				"BASE_IMAGE_URL": pulumi.String("https://dex-auth-n.dimo.zone/v1"),
+				"BASE_IMAGE_URL": pulumi.String("https://" + nodeConfig.Host("dex-auth-n") + "/v1"),
 			},
 		},
-	}, pulumi.Provider(kubeProvider))
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses),
+		utils.DependsOnSecrets(dexSecret))
 	if err != nil {
 		return err
 	}
@@ -1,20 +1,23 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 	//"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func InstallDexAuthZ(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart) (err error) {
+func InstallDexAuthZ(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
 	//conf := config.New(ctx, "")
 	//environmentName := conf.Require("environment")
 	//Deploy the users-api from helm chart
 	usersApi, err := helm.NewChart(ctx, "dex-auth-z", helm.ChartArgs{
-		Chart:     pulumi.String("dimo-dex"),
-		Path:      pulumi.String("./applications/cluster-helm-charts/charts"),
-		Namespace: pulumi.String("dex"),
+		Chart:           pulumi.String("dimo-dex"),
+		Path:            pulumi.String("./applications/cluster-helm-charts/charts"),
+		Namespace:       pulumi.String("dex"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCore)},
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"), // We need to push public versions of the images to docker.io
@@ -29,7 +32,7 @@ func InstallDexAuthZ(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, Sec
 				"enabled": pulumi.Bool(true),
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("dex-auth-z.dimo.zone"), // TODO: Get host from cloud provider
+						"host": pulumi.String(nodeConfig.Host("dex-auth-z")),
 						// Find how to only pass in identity-api and get the service from the environment
 						"paths": pulumi.Array{
 							pulumi.Map{
@@ -44,10 +47,10 @@ func InstallDexAuthZ(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, Sec
 			// Could use mumbai testnet contracts also in the short term
 			// Define sets of addresses at the top level for different environments
 			"env": pulumi.Map{
-				"BASE_IMAGE_URL": pulumi.String("https://dex-auth-z.dimo.zone/v1"),
+				"BASE_IMAGE_URL": pulumi.String("https://" + nodeConfig.Host("dex-auth-z") + "/v1"),
 			},
 		},
-	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{SecretsProvider}), pulumi.IgnoreChanges([]string{"spec"}), pulumi.Transformations([]pulumi.ResourceTransformation{
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(append([]pulumi.Resource{SecretsProvider}, priorityClasses...)), pulumi.IgnoreChanges([]string{"spec"}), pulumi.Transformations([]pulumi.ResourceTransformation{
 		func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
 			if args.Type == "kubernetes:external-secrets.io/v1beta1:ExternalSecret" {
 				return &pulumi.ResourceTransformationResult{
@@ -1,17 +1,14 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
-	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
-	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func InstallIdentityApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart) (err error) {
-	conf := config.New(ctx, "")
-	environmentName := conf.Require("environment")
+func InstallIdentityApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, SecretsProvider *helm.Chart, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
+	environmentName := nodeConfig.Environment
 
 	//transform := func(_ context.Context, args *pulumi.ResourceTransformArgs) *pulumi.ResourceTransformResult {
 	//if args.Type == "aws:ec2/vpc:Vpc" || args.Type == "aws:ec2/subnet:Subnet" {
@@ -24,50 +21,13 @@ func InstallIdentityApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 	//return nil
 	//}
 
-	_, err = apiextensions.NewCustomResource(ctx, "external-secret-identity-api", &apiextensions.CustomResourceArgs{
-		ApiVersion: pulumi.String("external-secrets.io/v1beta1"),
-		Kind:       pulumi.String("ExternalSecret"),
-		Metadata: &metav1.ObjectMetaArgs{
-			Name:      pulumi.String("identity-api-secret"),
-			Namespace: pulumi.String("identity"),
-		},
-		OtherFields: map[string]any{
-			"spec": map[string]any{
-				"secretStoreRef": map[string]any{
-					"name": pulumi.String("cluster-secret-store"),
-				},
-				"target": map[string]any{
-					"name": pulumi.String("identity-api-secret"),
-				},
-				"data": pulumi.Array{
-					pulumi.Map{
-						"secretKey": pulumi.String("secret"),
-						"remoteRef": pulumi.Map{
-							"key": pulumi.String("identity-api-secret"),
-						},
-					},
-				},
-			},
-		},
-	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{SecretsProvider}), pulumi.DeleteBeforeReplace(true), pulumi.ReplaceOnChanges([]string{"spec"}))
-	//}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{SecretsProvider}), pulumi.IgnoreChanges([]string{"spec"}))
-	//	pulumi.Transformations([]pulumi.ResourceTransformation{
-	//		func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
-	//			if args.Type == "kubernetes:admissionregistration.k8s.io/v1:ValidatingWebhookConfiguration" ||
-	//				args.Type == "kubernetes:admissionregistration.k8s.io/v1:MutatingWebhookConfiguration" {
-	//				return &pulumi.ResourceTransformationResult{
-	//					Props: args.Props,
-	//					Opts: append(args.Opts, pulumi.IgnoreChanges([]string{
-	//						"spec.data",
-	//						"spec.secretStoreRef.name",
-	//					})),
-	//				}
-	//			}
-	//			return nil
-	//		},
-	//	}),
-	//)
-
+	// The release waits for the secret to sync, so the pods don't start without it
+	identitySecret, err := utils.NewExternalSecret(ctx, "external-secret-identity-api", kubeProvider, utils.ExternalSecretArgs{
+		Namespace:  "identity",
+		SecretName: "identity-api-secret",
+		Data:       map[string]string{"secret": utils.IdentityAPISecretID},
+		DependsOn:  []pulumi.Resource{SecretsProvider},
+	})
 	if err != nil {
 		return err
 	}
@@ -77,6 +37,8 @@ func InstallIdentityApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 		Name:      pulumi.String("identity-api"),
 		Chart:     pulumi.String("./applications/identity-api/charts/identity-api"),
 		Namespace: pulumi.String("identity"),
+		// Releases are rendered by helm, so the tier is applied by the post-renderer in cmd/priority-tier
+		Postrender: pulumi.String("priority-tier"),
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"), // We need to push public versions of the images to docker.io
@@ -91,7 +53,7 @@ func InstallIdentityApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 				"enabled": pulumi.Bool(true),
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("identity-api.dimo.zone"),
+						"host": pulumi.String(nodeConfig.Host("identity-api")),
 						"paths": pulumi.Array{
 							pulumi.Map{
 								"path":     pulumi.String("/"),
@@ -120,7 +82,8 @@ func InstallIdentityApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider,
 		SkipAwait:     pulumi.Bool(true),
 		WaitForJobs:   pulumi.Bool(false),
 		CleanupOnFail: pulumi.Bool(true),
-	}, pulumi.Provider(kubeProvider),
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses),
+		utils.DependsOnSecrets(identitySecret),
 		pulumi.Transformations([]pulumi.ResourceTransformation{
 			func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
 				if args.Type == "kubernetes:networking.k8s.io/v1:Ingress" {
@@ -1,6 +1,7 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
@@ -9,7 +10,59 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallKubePrometheus(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, namespace *corev1.Namespace) (err error) {
+func InstallKubePrometheus(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, namespace *corev1.Namespace, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
+	values := pulumi.Map{
+		"grafana": pulumi.Map{
+			"admin": pulumi.Map{
+				"existingSecret": pulumi.String("grafana-password-secret"),
+				"passwordKey":    pulumi.String("password"),
+			},
+			"resources":         utils.PodResources("50m", "128Mi"),
+			"priorityClassName": pulumi.String(utils.PriorityCore),
+		},
+		"prometheusOperator": pulumi.Map{
+			"resources":         utils.PodResources("50m", "64Mi"),
+			"priorityClassName": pulumi.String(utils.PriorityCore),
+			"admissionWebhooks": pulumi.Map{
+				"patch": pulumi.Map{
+					"priorityClassName": pulumi.String(utils.PriorityBatch),
+				},
+			},
+		},
+		"prometheus": pulumi.Map{
+			"prometheusSpec": pulumi.Map{
+				"resources":         utils.PodResources("100m", "512Mi"),
+				"priorityClassName": pulumi.String(utils.PriorityCore),
+			},
+		},
+		"alertmanager": pulumi.Map{
+			"alertmanagerSpec": pulumi.Map{
+				"resources":         utils.PodResources("10m", "64Mi"),
+				"priorityClassName": pulumi.String(utils.PriorityCore),
+			},
+		},
+		"kube-state-metrics": pulumi.Map{
+			"resources":         utils.PodResources("10m", "64Mi"),
+			"priorityClassName": pulumi.String(utils.PriorityCore),
+		},
+		"prometheus-node-exporter": pulumi.Map{
+			"priorityClassName": pulumi.String(utils.PriorityCore),
+		},
+	}
+
+	// Autopilot rejects the node-exporter DaemonSet (host network and PID) and any write to kube-system,
+	// which is where the chart puts the services for scraping the kubelet and the control plane
+	if nodeConfig.DeploymentType == "gke-autopilot" {
+		values["nodeExporter"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["kubelet"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["kubeControllerManager"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["kubeScheduler"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["kubeEtcd"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["kubeProxy"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["coreDns"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+		values["prometheusOperator"].(pulumi.Map)["kubeletService"] = pulumi.Map{"enabled": pulumi.Bool(false)}
+	}
+
 	_, err = helm.NewRelease(ctx, "kube-prometheus-stack", &helm.ReleaseArgs{
 		Name:    pulumi.String("kube-prometheus-stack"),
 		Chart:   pulumi.String("kube-prometheus-stack"),
@@ -22,28 +75,21 @@ func InstallKubePrometheus(ctx *pulumi.Context, kubeProvider *kubernetes.Provide
 		SkipAwait:       pulumi.Bool(true),
 		WaitForJobs:     pulumi.Bool(false),
 		Replace:         pulumi.Bool(true),
-		Values: pulumi.Map{
-			"grafana": pulumi.Map{
-				"admin": pulumi.Map{
-					"existingSecret": pulumi.String("grafana-password-secret"),
-					"passwordKey":    pulumi.String("password"),
-				},
-			},
-		},
-	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{namespace}))
+		Values:          values,
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses), pulumi.DependsOn([]pulumi.Resource{namespace}))
 	if err != nil {
 		return err
 	}
 
 	// Create Grafana ingress
-	if err := createGrafanaIngress(ctx, kubeProvider); err != nil {
+	if err := createGrafanaIngress(ctx, kubeProvider, nodeConfig.GrafanaHost); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func createGrafanaIngress(ctx *pulumi.Context, provider *kubernetes.Provider) error {
+func createGrafanaIngress(ctx *pulumi.Context, provider *kubernetes.Provider, host string) error {
 	_, err := networkingv1.NewIngress(ctx, "grafana-ingress", &networkingv1.IngressArgs{
 		Metadata: &metav1.ObjectMetaArgs{
 			Name:      pulumi.String("grafana"),
@@ -57,13 +103,13 @@ func createGrafanaIngress(ctx *pulumi.Context, provider *kubernetes.Provider) er
 			IngressClassName: pulumi.String("nginx"),
 			Tls: networkingv1.IngressTLSArray{
 				&networkingv1.IngressTLSArgs{
-					Hosts:      pulumi.StringArray{pulumi.String("monitoring.driveomid.xyz")},
+					Hosts:      pulumi.StringArray{pulumi.String(host)},
 					SecretName: pulumi.String("grafana-tls"),
 				},
 			},
 			Rules: networkingv1.IngressRuleArray{
 				&networkingv1.IngressRuleArgs{
-					Host: pulumi.String("monitoring.driveomid.xyz"),
+					Host: pulumi.String(host),
 					Http: &networkingv1.HTTPIngressRuleValueArgs{
 						Paths: networkingv1.HTTPIngressPathArray{
 							&networkingv1.HTTPIngressPathArgs{
@@ -1,19 +1,22 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallMQTTBroker(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (err error) {
+func InstallMQTTBroker(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
 	//conf := config.New(ctx, "")
 	//environmentName := conf.Require("environment")
 	//Deploy the users-api from helm chart
 	usersApi, err := helm.NewChart(ctx, "mqtt-broker", helm.ChartArgs{
-		Chart:     pulumi.String("dimo-emqx"),
-		Path:      pulumi.String("./applications/cluster-helm-charts/charts"),
-		Namespace: pulumi.String("identity"),
+		Chart:           pulumi.String("dimo-emqx"),
+		Path:            pulumi.String("./applications/cluster-helm-charts/charts"),
+		Namespace:       pulumi.String("identity"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCore)},
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"), // We need to push public versions of the images to docker.io
@@ -28,7 +31,7 @@ func InstallMQTTBroker(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (
 				"enabled": pulumi.Bool(true),
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("mqtt-broker.dimo.zone"), // TODO: Get host from cloud provider
+						"host": pulumi.String(nodeConfig.Host("mqtt-broker")),
 						// Find how to only pass in identity-api and get the service from the environment
 						"paths": pulumi.Array{
 							pulumi.Map{
@@ -43,10 +46,10 @@ func InstallMQTTBroker(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (
 			// Could use mumbai testnet contracts also in the short term
 			// Define sets of addresses at the top level for different environments
 			"env": pulumi.Map{
-				"BASE_IMAGE_URL": pulumi.String("https://mqtt-broker.dimo.zone/v1"),
+				"BASE_IMAGE_URL": pulumi.String("https://" + nodeConfig.Host("mqtt-broker") + "/v1"),
 			},
 		},
-	}, pulumi.Provider(kubeProvider))
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses))
 	if err != nil {
 		return err
 	}
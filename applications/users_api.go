@@ -1,19 +1,22 @@
 package applications
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallUsersApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (err error) {
+func InstallUsersApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (err error) {
 	//Deploy the users-api from helm chart
 	usersApi, err := helm.NewChart(ctx, "users-api", helm.ChartArgs{
 		Chart: pulumi.String("users-api"),
 		FetchArgs: helm.FetchArgs{
 			Repo: pulumi.String("https://dimo-network.github.io/users-api"),
 		},
-		Namespace: pulumi.String("users"),
+		Namespace:       pulumi.String("users"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCore)},
 		Values: pulumi.Map{
 			"global": pulumi.Map{
 				"imageRegistry": pulumi.String("docker.io"),
@@ -29,7 +32,7 @@ func InstallUsersApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (er
 				"enabled": pulumi.Bool(true),
 				"hosts": pulumi.Array{
 					pulumi.Map{
-						"host": pulumi.String("users-api.dimo.zone"), // TODO: Get host from cloud provider
+						"host": pulumi.String(nodeConfig.Host("users-api")),
 						"paths": pulumi.Array{
 							pulumi.Map{
 								"path": pulumi.String("/"),
@@ -51,7 +54,7 @@ func InstallUsersApi(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (er
 				"database": pulumi.String("postgres"),
 			},
 		},
-	}, pulumi.Provider(kubeProvider))
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(priorityClasses))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dimo/dimo-node/utils"
+)
+
+// gke-credentials is the kubeconfig exec plugin for the GKE clusters this repo builds.
+// It prints a fresh access token, so neither gcloud nor gke-gcloud-auth-plugin has to be installed.
+func main() {
+	var credentialsJSON []byte
+	if encoded := os.Getenv(utils.GKECredentialsEnv); encoded != "" {
+		var err error
+		credentialsJSON, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Fatalf("Error decoding %s: %v", utils.GKECredentialsEnv, err)
+		}
+	}
+
+	credential, err := utils.GKEExecCredential(string(credentialsJSON))
+	if err != nil {
+		log.Fatalf("Error getting GKE credentials: %v", err)
+	}
+
+	fmt.Println(string(credential))
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/dimo/dimo-node/utils"
+)
+
+// priority-tier is the helm post-renderer for the application releases. It schedules their pods as dimo-core,
+// and their jobs as dimo-batch, whatever values the chart reads.
+func main() {
+	manifests, err := utils.PriorityTierManifests(os.Stdin, utils.PriorityCore)
+	if err != nil {
+		log.Fatalf("Error applying the priority tier: %v", err)
+	}
+
+	if _, err := os.Stdout.Write(manifests); err != nil {
+		log.Fatalf("Error writing the manifests: %v", err)
+	}
+}
@@ -1,13 +1,14 @@
 package dependencies
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallCertificates(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) error {
+func InstallCertificates(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) error {
 	// Install cert-manager and Let's Encrypt
-	err := InstallLetsEncrypt(ctx, kubeProvider)
+	err := InstallLetsEncrypt(ctx, kubeProvider, nodeConfig, priorityClasses)
 	if err != nil {
 		return err
 	}
@@ -1,8 +1,8 @@
 package dependencies
 
 import (
-	"github.com/dimo/dimo-node/infrastructure"
 	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
@@ -12,8 +12,8 @@ import (
 
 // Define variables needed globally in the dependencies package
 
-func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
-	_, err = utils.CreateNamespaces(ctx, infrastructure.KubeProvider, []string{"postgres"})
+func InstallDatabaseDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, priorityClasses []pulumi.Resource) (err error) {
+	_, err = utils.CreateNamespaces(ctx, kubeProvider, []string{"postgres"})
 	if err != nil {
 		return err
 	}
@@ -27,7 +27,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 	// 	StringData: pulumi.StringMap{
 	// 		"verifier": pulumi.String("SCRAM-SHA-256$4096:w]5lKc-/F-Cja^ew@01Ror_,%"),
 	// 	},
-	// }, pulumi.Provider(infrastructure.KubeProvider))
+	// }, pulumi.Provider(kubeProvider))
 	// if err != nil {
 	// 	return err
 	// }
@@ -36,7 +36,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 		Metadata: &metav1.ObjectMetaArgs{
 			Namespace: pulumi.String("postgres"),
 		},
-	}, pulumi.Provider(infrastructure.KubeProvider))
+	}, pulumi.Provider(kubeProvider))
 	if err != nil {
 		return err
 	}
@@ -49,7 +49,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 		},
 		Namespace: pulumi.String("postgres"),
 		Version:   pulumi.String("5.5.1"), // replace with the desired chart version
-	}, pulumi.Provider(infrastructure.KubeProvider))
+	}, pulumi.Provider(kubeProvider))
 	if err != nil {
 		return err
 	}
@@ -66,8 +66,9 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 				"postgresVersion": pulumi.Int(16),
 				"instances": pulumi.Array{
 					pulumi.Map{
-						"name":     pulumi.String("instance1"),
-						"replicas": pulumi.Int(2),
+						"name":              pulumi.String("instance1"),
+						"replicas":          pulumi.Int(2),
+						"priorityClassName": pulumi.String(utils.PriorityCore),
 						"dataVolumeClaimSpec": pulumi.Map{
 							"storageClassName": pulumi.String("standard"),
 							"accessModes":      pulumi.StringArray{pulumi.String("ReadWriteOnce")},
@@ -109,6 +110,13 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 				"backups": map[string]any{
 					"pgbackrest": pulumi.Map{
 						"image": pulumi.String("registry.developers.crunchydata.com/crunchydata/crunchy-pgbackrest:ubi8-2.45-2"),
+						"repoHost": pulumi.Map{
+							"priorityClassName": pulumi.String(utils.PriorityCore),
+						},
+						// Backups are jobs, they shouldn't push the database or the applications off a full node
+						"jobs": pulumi.Map{
+							"priorityClassName": pulumi.String(utils.PriorityBatch),
+						},
 						"repos": pulumi.Array{
 							pulumi.Map{
 								"name": pulumi.String("repo1"),
@@ -129,7 +137,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 			},
 			// Define other properties like storage, backups, and user configuration.
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{postgresOperatorChart}), pulumi.Provider(infrastructure.KubeProvider))
+	}, pulumi.DependsOn(append([]pulumi.Resource{postgresOperatorChart}, priorityClasses...)), pulumi.Provider(kubeProvider))
 	if err != nil {
 		return err
 	}
@@ -160,7 +168,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 	// 			},
 	// 		},
 	// 	},
-	// }, pulumi.Provider(infrastructure.KubeProvider))
+	// }, pulumi.Provider(kubeProvider))
 	// if err != nil {
 	// 	return err
 	// }
@@ -187,7 +195,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 	// 			"type": pulumi.String("ClusterIP"),
 	// 		},
 	// 	},
-	// }, pulumi.Provider(infrastructure.KubeProvider))
+	// }, pulumi.Provider(kubeProvider))
 	// if err != nil {
 	// 	return err
 	// }
@@ -197,7 +205,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 	// // Deploy a postgres cluster with a custom resource definition
 	// postgresClusterCRD, err := yaml.NewConfigFile(ctx, "postgres-cluster-crd", &yaml.ConfigFileArgs{
 	// 	File: string("./dependencies/crds/postgres-cluster-crd.yaml"),
-	// }, pulumi.Provider(infrastructure.KubeProvider))
+	// }, pulumi.Provider(kubeProvider))
 	// if err != nil {
 	// 	return err
 	// }
@@ -225,7 +233,7 @@ func InstallDatabaseDependencies(ctx *pulumi.Context) (err error) {
 				},
 			},
 			// TODO: Add NS dependency back to this: pulumi.DependsOn([]pulumi.Resource{ns})
-		}, pulumi.Provider(infrastructure.KubeProvider))
+		}, pulumi.Provider(kubeProvider))
 		if err != nil {
 			return err
 		}
@@ -1,86 +1,137 @@
 package dependencies
 
 import (
+	"github.com/dimo/dimo-node/infrastructure"
 	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallDependencies(ctx *pulumi.Context, provider *kubernetes.Provider) (error, *helm.Chart) {
+func InstallDependencies(ctx *pulumi.Context, cluster *infrastructure.BuildResult, nodeConfig *utils.NodeConfig) (error, *helm.Chart) {
+	provider := cluster.KubeProvider
+	// Everything scheduled with a priority tier waits for its class, a pod naming a missing class is rejected
+	priorityClasses := cluster.PriorityClasses
+
 	// Install nginx-ingress
-	if err := InstallNginxIngress(ctx, provider); err != nil {
+	if err := InstallNginxIngress(ctx, provider, nodeConfig, cluster.IngressAddress, priorityClasses); err != nil {
 		return err, nil
 	}
 
+	// Publish the ingress hosts before cert-manager's HTTP challenges need them to resolve
+	if nodeConfig.ExternalDNS {
+		if err := InstallExternalDNS(ctx, provider, nodeConfig, cluster.OidcProvider, priorityClasses); err != nil {
+			return err, nil
+		}
+	}
+
 	// Install cert-manager and configure Let's Encrypt
-	if err := InstallLetsEncrypt(ctx, provider); err != nil {
+	if err := InstallLetsEncrypt(ctx, provider, nodeConfig, priorityClasses); err != nil {
 		return err, nil
 	}
 
 	// Install external-secrets operator and get the ClusterSecretStore
-	secretsProvider, err := InstallSecretsDependencies(ctx, provider)
+	secretsProvider, err := InstallSecretsDependencies(ctx, provider, nodeConfig, cluster.OidcProvider, priorityClasses)
 	if err != nil {
 		return err, nil
 	}
 
 	// Install database dependencies
-	if err := InstallDatabaseDependencies(ctx); err != nil {
+	if err := InstallDatabaseDependencies(ctx, provider, priorityClasses); err != nil {
 		return err, nil
 	}
 
 	return nil, secretsProvider
 }
 
-func InstallNginxIngress(ctx *pulumi.Context, provider *kubernetes.Provider) error {
+// InstallNginxIngress installs ingress-nginx, pinned to address when the infrastructure reserved one
+func InstallNginxIngress(ctx *pulumi.Context, provider *kubernetes.Provider, nodeConfig *utils.NodeConfig, address *infrastructure.IngressAddress, priorityClasses []pulumi.Resource) error {
 	// Create namespace for nginx-ingress
 	namespaces, err := utils.CreateNamespaces(ctx, provider, []string{"ingress-nginx"})
 	if err != nil {
 		return err
 	}
 
+	controller := pulumi.Map{
+		"image": pulumi.Map{
+			"chroot": pulumi.Bool(true),
+		},
+		"kind":         pulumi.String("Deployment"),
+		"replicaCount": pulumi.Int(2),
+		"ingressClassResource": pulumi.Map{
+			"name":            pulumi.String("nginx"),
+			"enabled":         pulumi.Bool(true),
+			"default":         pulumi.Bool(false),
+			"controllerValue": pulumi.String("k8s.io/ingress-nginx"),
+		},
+		"metrics": pulumi.Map{
+			"enabled": pulumi.Bool(true),
+			"serviceMonitor": pulumi.Map{
+				"enabled": pulumi.Bool(true),
+			},
+		},
+		// The admission webhook certificate jobs run once per install, Autopilot still wants requests on them
+		"admissionWebhooks": pulumi.Map{
+			"createSecretJob": pulumi.Map{
+				"resources": utils.PodResources("10m", "20Mi"),
+			},
+			"patchWebhookJob": pulumi.Map{
+				"resources": utils.PodResources("10m", "20Mi"),
+			},
+		},
+		"resources": pulumi.Map{
+			"requests": pulumi.Map{
+				"cpu":    pulumi.String("100m"),
+				"memory": pulumi.String("200Mi"),
+			},
+			"limits": pulumi.Map{
+				"cpu":    pulumi.String("500m"),
+				"memory": pulumi.String("500Mi"),
+			},
+		},
+	}
+
+	// kind has no load balancer, the ports are mapped straight onto the ingress-ready control plane node
+	if nodeConfig.DeploymentType == "kind" {
+		controller["replicaCount"] = pulumi.Int(1)
+		controller["hostPort"] = pulumi.Map{
+			"enabled": pulumi.Bool(true),
+		}
+		controller["service"] = pulumi.Map{
+			"type": pulumi.String("NodePort"),
+		}
+		controller["nodeSelector"] = pulumi.StringMap{
+			"ingress-ready": pulumi.String("true"),
+		}
+		controller["tolerations"] = pulumi.Array{
+			pulumi.Map{
+				"key":      pulumi.String("node-role.kubernetes.io/control-plane"),
+				"operator": pulumi.String("Exists"),
+				"effect":   pulumi.String("NoSchedule"),
+			},
+		}
+	}
+
+	if service := ingressServiceValues(nodeConfig, address); service != nil {
+		controller["service"] = service
+	}
+
 	// Install main nginx-ingress controller
 	_, err = helm.NewChart(ctx, "ingress-nginx", helm.ChartArgs{
 		Chart: pulumi.String("ingress-nginx"),
 		FetchArgs: helm.FetchArgs{
 			Repo: pulumi.String("https://kubernetes.github.io/ingress-nginx"),
 		},
-		Version:   pulumi.String("4.11.2"),
-		Namespace: pulumi.String("ingress-nginx"),
+		Version:         pulumi.String("4.11.2"),
+		Namespace:       pulumi.String("ingress-nginx"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCritical)},
 		Values: pulumi.Map{
 			"enable-stub-status": pulumi.Bool(true),
 			"stub-status-path":   pulumi.String("/nginx_status"),
-			"controller": pulumi.Map{
-				"image": pulumi.Map{
-					"chroot": pulumi.Bool(true),
-				},
-				"kind":         pulumi.String("Deployment"),
-				"replicaCount": pulumi.Int(2),
-				"ingressClassResource": pulumi.Map{
-					"name":            pulumi.String("nginx"),
-					"enabled":         pulumi.Bool(true),
-					"default":         pulumi.Bool(false),
-					"controllerValue": pulumi.String("k8s.io/ingress-nginx"),
-				},
-				"metrics": pulumi.Map{
-					"enabled": pulumi.Bool(true),
-					"serviceMonitor": pulumi.Map{
-						"enabled": pulumi.Bool(true),
-					},
-				},
-				"resources": pulumi.Map{
-					"requests": pulumi.Map{
-						"cpu":    pulumi.String("100m"),
-						"memory": pulumi.String("200Mi"),
-					},
-					"limits": pulumi.Map{
-						"cpu":    pulumi.String("500m"),
-						"memory": pulumi.String("500Mi"),
-					},
-				},
-			},
+			"controller":         controller,
 		},
-	}, pulumi.Provider(provider), pulumi.DependsOn([]pulumi.Resource{namespaces["ingress-nginx"]}))
+	}, pulumi.Provider(provider), pulumi.DependsOn(append([]pulumi.Resource{namespaces["ingress-nginx"]}, priorityClasses...)))
 
 	if err != nil {
 		return err
@@ -88,3 +139,42 @@ func InstallNginxIngress(ctx *pulumi.Context, provider *kubernetes.Provider) err
 
 	return nil
 }
+
+// ingressServiceValues picks an internal or external load balancer for ingress-nginx and pins it to the
+// address reserved in infrastructure, nil where the chart's default LoadBalancer service is left as is
+func ingressServiceValues(nodeConfig *utils.NodeConfig, address *infrastructure.IngressAddress) pulumi.Map {
+	internal := nodeConfig.IngressLoadBalancer == "internal"
+	annotations := pulumi.StringMap{}
+	service := pulumi.Map{
+		"type": pulumi.String("LoadBalancer"),
+	}
+
+	switch nodeConfig.DeploymentType {
+	case "gke", "gke-autopilot":
+		if internal {
+			annotations["networking.gke.io/load-balancer-type"] = pulumi.String("Internal")
+		}
+		if address != nil {
+			service["loadBalancerIP"] = address.IP
+		}
+	case "eks":
+		// Only an NLB can take Elastic IPs
+		annotations["service.beta.kubernetes.io/aws-load-balancer-type"] = pulumi.String("nlb")
+		if internal {
+			annotations["service.beta.kubernetes.io/aws-load-balancer-internal"] = pulumi.String("true")
+		}
+		if address != nil {
+			annotations["service.beta.kubernetes.io/aws-load-balancer-eip-allocations"] = address.AllocationIds
+		}
+	case "aks":
+		if !internal {
+			return nil
+		}
+		annotations["service.beta.kubernetes.io/azure-load-balancer-internal"] = pulumi.String("true")
+	default:
+		return nil
+	}
+
+	service["annotations"] = annotations
+	return service
+}
@@ -0,0 +1,275 @@
+package dependencies
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/route53"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/dns"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/projects"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/serviceaccount"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// external-dns runs as this service account, workload identity and IRSA are both scoped to it
+const externalDNSNamespace = "external-dns"
+const externalDNSServiceAccount = "external-dns"
+
+// InstallExternalDNS creates or adopts the zone for dns-domain and installs external-dns,
+// which keeps a record in the zone for every ingress host
+func InstallExternalDNS(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, oidcProvider *iam.OpenIdConnectProvider, priorityClasses []pulumi.Resource) error {
+	namespaces, err := utils.CreateNamespaces(ctx, kubeProvider, []string{externalDNSNamespace})
+	if err != nil {
+		return err
+	}
+	ns := namespaces[externalDNSNamespace]
+
+	serviceAccount := pulumi.Map{
+		"create": pulumi.Bool(true),
+		"name":   pulumi.String(externalDNSServiceAccount),
+	}
+	values := pulumi.Map{
+		"domainFilters": pulumi.StringArray{pulumi.String(nodeConfig.DNSDomain)},
+		// TXT ownership records keep nodes sharing a zone from deleting each other's records
+		"txtOwnerId": pulumi.String(nodeConfig.ProjectName),
+		"policy":     pulumi.String("sync"),
+		"sources":    pulumi.StringArray{pulumi.String("ingress"), pulumi.String("service")},
+		"resources":  utils.PodResources("10m", "64Mi"),
+	}
+	dependsOn := []pulumi.Resource{ns}
+
+	switch nodeConfig.CloudProvider {
+	case "gcp":
+		values["provider"] = pulumi.Map{"name": pulumi.String("google")}
+		values["extraArgs"] = pulumi.StringArray{pulumi.String("--google-project=" + nodeConfig.GCPProject)}
+
+		if nodeConfig.DNSZone == "" {
+			if err := createCloudDNSZone(ctx, nodeConfig); err != nil {
+				return err
+			}
+		}
+
+		if nodeConfig.GCPWorkloadIdentity {
+			gsa, err := createExternalDNSGSA(ctx, nodeConfig.ProjectName, nodeConfig.GCPProject)
+			if err != nil {
+				return err
+			}
+			serviceAccount["annotations"] = pulumi.StringMap{
+				"iam.gke.io/gcp-service-account": gsa.Email,
+			}
+		} else {
+			// Without workload identity external-dns uses the gcp-credentials key, which needs DNS Administrator
+			keySecret, err := corev1.NewSecret(ctx, "external-dns-credentials", &corev1.SecretArgs{
+				Metadata: &metav1.ObjectMetaArgs{
+					Name:      pulumi.String("external-dns-credentials"),
+					Namespace: pulumi.String(externalDNSNamespace),
+				},
+				StringData: pulumi.StringMap{
+					"credentials.json": *nodeConfig.GCPCredentials,
+				},
+				Type: pulumi.String("Opaque"),
+			}, pulumi.Provider(kubeProvider),
+				pulumi.DependsOn([]pulumi.Resource{ns}))
+			if err != nil {
+				return err
+			}
+			dependsOn = append(dependsOn, keySecret)
+
+			values["env"] = pulumi.Array{
+				pulumi.Map{
+					"name":  pulumi.String("GOOGLE_APPLICATION_CREDENTIALS"),
+					"value": pulumi.String("/etc/external-dns/credentials.json"),
+				},
+			}
+			values["extraVolumes"] = pulumi.Array{
+				pulumi.Map{
+					"name": pulumi.String("credentials"),
+					"secret": pulumi.Map{
+						"secretName": pulumi.String("external-dns-credentials"),
+					},
+				},
+			}
+			values["extraVolumeMounts"] = pulumi.Array{
+				pulumi.Map{
+					"name":      pulumi.String("credentials"),
+					"mountPath": pulumi.String("/etc/external-dns"),
+					"readOnly":  pulumi.Bool(true),
+				},
+			}
+		}
+	case "aws":
+		zoneId := pulumi.String(nodeConfig.DNSZone).ToStringOutput()
+		if nodeConfig.DNSZone == "" {
+			zoneId, err = createRoute53Zone(ctx, nodeConfig)
+			if err != nil {
+				return err
+			}
+		}
+
+		role, err := createExternalDNSRole(ctx, oidcProvider, zoneId)
+		if err != nil {
+			return err
+		}
+
+		values["provider"] = pulumi.Map{"name": pulumi.String("aws")}
+		values["zoneIdFilters"] = pulumi.StringArray{zoneId}
+		values["env"] = pulumi.Array{
+			pulumi.Map{
+				"name":  pulumi.String("AWS_DEFAULT_REGION"),
+				"value": pulumi.String(nodeConfig.Region),
+			},
+		}
+		serviceAccount["annotations"] = pulumi.StringMap{
+			"eks.amazonaws.com/role-arn": role.Arn,
+		}
+	default:
+		return fmt.Errorf("external-dns is not supported on %s", nodeConfig.CloudProvider)
+	}
+	values["serviceAccount"] = serviceAccount
+
+	externalDNS, err := helm.NewChart(ctx, "external-dns", helm.ChartArgs{
+		Chart: pulumi.String("external-dns"),
+		FetchArgs: helm.FetchArgs{
+			Repo: pulumi.String("https://kubernetes-sigs.github.io/external-dns/"),
+		},
+		Version:         pulumi.String("1.15.0"),
+		Namespace:       pulumi.String(externalDNSNamespace),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCritical)},
+		Values:          values,
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn(append(dependsOn, priorityClasses...)))
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("externalDNS", externalDNS.URN())
+
+	return nil
+}
+
+// createCloudDNSZone creates a public Cloud DNS zone for dns-domain, the parent domain has to delegate to its name servers
+func createCloudDNSZone(ctx *pulumi.Context, nodeConfig *utils.NodeConfig) error {
+	zone, err := dns.NewManagedZone(ctx, "dns-zone", &dns.ManagedZoneArgs{
+		Name:        pulumi.String(nodeConfig.ProjectName + "-zone"),
+		DnsName:     pulumi.String(nodeConfig.DNSDomain + "."),
+		Description: pulumi.String("DIMO node records managed by external-dns"),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("dnsNameServers", zone.NameServers)
+
+	return nil
+}
+
+// createExternalDNSGSA creates the Google service account external-dns runs as through workload identity.
+// The account id carries the project name so stacks sharing a GCP project each get their own.
+func createExternalDNSGSA(ctx *pulumi.Context, projectName string, projectID string) (*serviceaccount.Account, error) {
+	gsa, err := serviceaccount.NewAccount(ctx, "external-dns-account", &serviceaccount.AccountArgs{
+		AccountId: pulumi.String(gcpAccountID(projectName, "external-dns")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = serviceaccount.NewIAMMember(ctx, "external-dns-workload-identity", &serviceaccount.IAMMemberArgs{
+		ServiceAccountId: gsa.Name,
+		Role:             pulumi.String("roles/iam.workloadIdentityUser"),
+		Member:           pulumi.String(fmt.Sprintf("serviceAccount:%s.svc.id.goog[%s/%s]", projectID, externalDNSNamespace, externalDNSServiceAccount)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A member rather than a binding, other DNS admins in the project are left alone
+	_, err = projects.NewIAMMember(ctx, "external-dns-admin", &projects.IAMMemberArgs{
+		Project: pulumi.String(projectID),
+		Role:    pulumi.String("roles/dns.admin"),
+		Member:  pulumi.Sprintf("serviceAccount:%s", gsa.Email),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gsa, nil
+}
+
+// createRoute53Zone creates a public hosted zone for dns-domain and returns its id
+func createRoute53Zone(ctx *pulumi.Context, nodeConfig *utils.NodeConfig) (pulumi.StringOutput, error) {
+	zone, err := route53.NewZone(ctx, "dns-zone", &route53.ZoneArgs{
+		Name:    pulumi.String(nodeConfig.DNSDomain),
+		Comment: pulumi.String("DIMO node records managed by external-dns"),
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	ctx.Export("dnsNameServers", zone.NameServers)
+
+	return zone.ZoneId, nil
+}
+
+// createExternalDNSRole creates the IAM role external-dns assumes through IRSA, it can only change records in the one zone
+func createExternalDNSRole(ctx *pulumi.Context, oidcProvider *iam.OpenIdConnectProvider, zoneId pulumi.StringOutput) (*iam.Role, error) {
+	assumeRolePolicy, err := irsaAssumeRolePolicy(oidcProvider, externalDNSNamespace, externalDNSServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := iam.NewRole(ctx, "external-dns-role", &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rolePolicy := zoneId.ApplyT(func(zoneId string) (string, error) {
+		policy, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect":   "Allow",
+					"Action":   []string{"route53:ChangeResourceRecordSets"},
+					"Resource": []string{"arn:aws:route53:::hostedzone/" + zoneId},
+				},
+				{
+					"Effect":   "Allow",
+					"Action":   []string{"route53:ListHostedZones", "route53:ListResourceRecordSets", "route53:ListTagsForResource"},
+					"Resource": []string{"*"},
+				},
+			},
+		})
+		return string(policy), err
+	}).(pulumi.StringOutput)
+
+	_, err = iam.NewRolePolicy(ctx, "external-dns-policy", &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: rolePolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// gcpAccountIDInvalid matches what a stack name can hold but a service account id can't
+var gcpAccountIDInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// gcpAccountID builds a service account id of <projectName>-<suffix>, cutting the project name down to the 30 characters GCP allows
+func gcpAccountID(projectName string, suffix string) string {
+	prefix := gcpAccountIDInvalid.ReplaceAllString(strings.ToLower(projectName), "-")
+	if maxPrefix := 30 - len(suffix) - 1; len(prefix) > maxPrefix {
+		prefix = prefix[:maxPrefix]
+	}
+	return strings.Trim(prefix, "-") + "-" + suffix
+}
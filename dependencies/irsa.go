@@ -0,0 +1,39 @@
+package dependencies
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// irsaAssumeRolePolicy is the trust policy for an IAM role that only the one Kubernetes service account
+// can assume, through the EKS cluster's OIDC provider
+func irsaAssumeRolePolicy(oidcProvider *iam.OpenIdConnectProvider, namespace string, serviceAccount string) (pulumi.StringOutput, error) {
+	if oidcProvider == nil {
+		return pulumi.StringOutput{}, fmt.Errorf("%s/%s needs the EKS cluster's OIDC provider to assume an IAM role", namespace, serviceAccount)
+	}
+
+	return pulumi.All(oidcProvider.Arn, oidcProvider.Url).ApplyT(func(args []interface{}) (string, error) {
+		issuer := strings.TrimPrefix(args[1].(string), "https://")
+		policy, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Federated": args[0].(string)},
+					"Action":    "sts:AssumeRoleWithWebIdentity",
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]string{
+							issuer + ":sub": fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount),
+							issuer + ":aud": "sts.amazonaws.com",
+						},
+					},
+				},
+			},
+		})
+		return string(policy), err
+	}).(pulumi.StringOutput), nil
+}
@@ -1,12 +1,13 @@
 package dependencies
 
 import (
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
 // Define variables needed globally in the dependencies package
 
-func InstallKafka(ctx *pulumi.Context) (err error) {
+func InstallKafka(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (err error) {
 	/*
 
 		// Install Kafka operator and cluster
@@ -27,7 +28,7 @@ func InstallKafka(ctx *pulumi.Context) (err error) {
 					},
 				},
 			},
-		}, pulumi.Provider(infrastructure.KubeProvider))
+		}, pulumi.Provider(kubeProvider))
 		if err != nil {
 			return err
 		}
@@ -65,7 +66,7 @@ func InstallKafka(ctx *pulumi.Context) (err error) {
 					"replicaCount": pulumi.Int(1),
 				},
 			},
-		}, pulumi.Provider(infrastructure.KubeProvider))
+		}, pulumi.Provider(kubeProvider))
 		if err != nil {
 			return err
 		}
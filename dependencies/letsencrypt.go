@@ -1,6 +1,7 @@
 package dependencies
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
@@ -9,7 +10,7 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func InstallCertManager(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (*helm.Release, error) {
+func InstallCertManager(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) (*helm.Release, error) {
 	// Create cert-manager namespace first and wait for it to be ready
 	ns, err := corev1.NewNamespace(ctx, "cert-manager", &corev1.NamespaceArgs{
 		Metadata: &metav1.ObjectMetaArgs{
@@ -21,6 +22,41 @@ func InstallCertManager(ctx *pulumi.Context, kubeProvider *kubernetes.Provider)
 		return nil, err
 	}
 
+	global := pulumi.Map{
+		"priorityClassName": pulumi.String(utils.PriorityCritical),
+	}
+	values := pulumi.Map{
+		"installCRDs": pulumi.Bool(true),
+		"global":      global,
+		"webhook": pulumi.Map{
+			"timeoutSeconds": pulumi.Int(30),
+			"resources":      utils.PodResources("10m", "32Mi"),
+		},
+		"cainjector": pulumi.Map{
+			"resources": utils.PodResources("10m", "64Mi"),
+		},
+		"startupapicheck": pulumi.Map{
+			"enabled": pulumi.Bool(false),
+		},
+		"resources": pulumi.Map{
+			"requests": pulumi.Map{
+				"cpu":    pulumi.String("10m"),
+				"memory": pulumi.String("32Mi"),
+			},
+			"limits": pulumi.Map{
+				"cpu":    pulumi.String("100m"),
+				"memory": pulumi.String("128Mi"),
+			},
+		},
+	}
+
+	// Autopilot doesn't let workloads write to kube-system, where cert-manager keeps its leader election lease by default
+	if nodeConfig.DeploymentType == "gke-autopilot" {
+		global["leaderElection"] = pulumi.Map{
+			"namespace": pulumi.String("cert-manager"),
+		}
+	}
+
 	// Install cert-manager with CRDs and all components
 	certManager, err := helm.NewRelease(ctx, "cert-manager", &helm.ReleaseArgs{
 		Chart:   pulumi.String("cert-manager"),
@@ -30,32 +66,14 @@ func InstallCertManager(ctx *pulumi.Context, kubeProvider *kubernetes.Provider)
 		},
 		Namespace:       pulumi.String("cert-manager"),
 		CreateNamespace: pulumi.Bool(false),
-		Values: pulumi.Map{
-			"installCRDs": pulumi.Bool(true),
-			"webhook": pulumi.Map{
-				"timeoutSeconds": pulumi.Int(30),
-			},
-			"startupapicheck": pulumi.Map{
-				"enabled": pulumi.Bool(false),
-			},
-			"resources": pulumi.Map{
-				"requests": pulumi.Map{
-					"cpu":    pulumi.String("10m"),
-					"memory": pulumi.String("32Mi"),
-				},
-				"limits": pulumi.Map{
-					"cpu":    pulumi.String("100m"),
-					"memory": pulumi.String("128Mi"),
-				},
-			},
-		},
-		SkipAwait:     pulumi.Bool(false),
-		WaitForJobs:   pulumi.Bool(false),
-		CleanupOnFail: pulumi.Bool(true),
-		Timeout:       pulumi.Int(600),
-		Replace:       pulumi.Bool(true),
+		Values:          values,
+		SkipAwait:       pulumi.Bool(false),
+		WaitForJobs:     pulumi.Bool(false),
+		CleanupOnFail:   pulumi.Bool(true),
+		Timeout:         pulumi.Int(600),
+		Replace:         pulumi.Bool(true),
 	}, pulumi.Provider(kubeProvider),
-		pulumi.DependsOn([]pulumi.Resource{ns}))
+		pulumi.DependsOn(append([]pulumi.Resource{ns}, priorityClasses...)))
 
 	if err != nil {
 		return nil, err
@@ -65,9 +83,9 @@ func InstallCertManager(ctx *pulumi.Context, kubeProvider *kubernetes.Provider)
 	return certManager, nil
 }
 
-func InstallLetsEncrypt(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) error {
+func InstallLetsEncrypt(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, priorityClasses []pulumi.Resource) error {
 	// Use the cert-manager installation from certificates.go
-	certManager, err := InstallCertManager(ctx, kubeProvider)
+	certManager, err := InstallCertManager(ctx, kubeProvider, nodeConfig, priorityClasses)
 	if err != nil {
 		return err
 	}
@@ -1,7 +1,6 @@
 package dependencies
 
 import (
-	"github.com/dimo/dimo-node/infrastructure"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
@@ -54,7 +53,7 @@ func InstallLinkerD(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (err
 				},
 			},
 		},
-	}, pulumi.Provider(infrastructure.KubeProvider))
+	}, pulumi.Provider(kubeProvider))
 	if err != nil {
 		return err
 	}
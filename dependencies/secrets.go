@@ -1,25 +1,28 @@
 package dependencies
 
 import (
+	"encoding/json"
 	"fmt"
 
-	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/projects"
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/secretmanager"
 	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/serviceaccount"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/helm/v3"
 	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
-	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
 // Define global variable for SecretsProvider
 var SecretsProvider *helm.Chart
 var ServiceAccountName = "dimo-secret-svc-account"
 
-func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) (*helm.Chart, error) {
+func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, oidcProvider *iam.OpenIdConnectProvider, priorityClasses []pulumi.Resource) (*helm.Chart, error) {
 	// Create external-secrets namespace first and wait for it to be ready
 	ns, err := corev1.NewNamespace(ctx, "external-secrets", &corev1.NamespaceArgs{
 		Metadata: &metav1.ObjectMetaArgs{
@@ -34,35 +37,44 @@ func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Pr
 		return nil, err
 	}
 
-	// Get project ID from config
-	conf := config.New(ctx, "")
-	projectID := conf.Require("gcp-project")
-	clusterName := conf.Require("cluster-name")
-	clusterLocation := conf.Require("location")
+	// The manifest's backend secrets are written first, the store and the consumers' ExternalSecrets read them
+	values, err := secretValues(ctx, nodeConfig)
+	if err != nil {
+		return nil, err
+	}
+	backendSecrets, err := createBackendSecrets(ctx, nodeConfig, values)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create GSA and KSA before installing external-secrets
-	gsa, err := CreateGSA(ctx, kubeProvider, projectID)
+	backend, err := newSecretsBackend(ctx, kubeProvider, nodeConfig, oidcProvider, ns, values, backendSecrets)
 	if err != nil {
 		return nil, err
 	}
 
-	ksa, err := CreateKSA(ctx, kubeProvider, gsa.Email, ns)
+	ksa, err := CreateKSA(ctx, kubeProvider, backend.annotations, ns)
 	if err != nil {
 		return nil, err
 	}
 
+	// The apps' own ExternalSecrets wait on the chart, so the chart waits on the manifest's backend secrets
+	chartDependsOn := append([]pulumi.Resource{ns, ksa}, inManifestOrder(nodeConfig, backendSecrets)...)
+
 	// Install external-secrets helm chart with explicit namespace dependency
 	SecretsProvider, err = helm.NewChart(ctx, "external-secrets", helm.ChartArgs{
 		Chart: pulumi.String("external-secrets"),
 		FetchArgs: helm.FetchArgs{
 			Repo: pulumi.String("https://charts.external-secrets.io/"),
 		},
-		Namespace: pulumi.String("external-secrets"),
+		Namespace:       pulumi.String("external-secrets"),
+		Transformations: []yaml.Transformation{utils.PriorityTier(utils.PriorityCritical)},
 		Values: pulumi.Map{
 			"installCRDs": pulumi.Bool(true),
+			"resources":   utils.PodResources("10m", "64Mi"),
 			"webhook": pulumi.Map{
-				"create": pulumi.Bool(true),
-				"port":   pulumi.Int(9443),
+				"create":    pulumi.Bool(true),
+				"port":      pulumi.Int(9443),
+				"resources": utils.PodResources("10m", "32Mi"),
 				"service": pulumi.Map{
 					"type": pulumi.String("ClusterIP"),
 					"ports": pulumi.Map{
@@ -71,17 +83,24 @@ func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Pr
 					},
 				},
 			},
+			"certController": pulumi.Map{
+				"resources": utils.PodResources("10m", "32Mi"),
+			},
 			"serviceAccount": pulumi.Map{
 				"create": pulumi.Bool(false),
 				"name":   pulumi.String("external-secrets-ksa"),
 			},
+			// The chart's own roles are scoped to what the controller does (external secrets, the secrets they
+			// write, and service account tokens for the stores), and are bound to external-secrets-ksa
+			"rbac": pulumi.Map{
+				"create": pulumi.Bool(true),
+			},
 			"podLabels": pulumi.StringMap{
 				"app.kubernetes.io/name": pulumi.String("external-secrets"),
 			},
-			"priorityClassName": pulumi.String("gmp-critical"),
 		},
 	}, pulumi.Provider(kubeProvider),
-		pulumi.DependsOn([]pulumi.Resource{ns, ksa}))
+		pulumi.DependsOn(append(chartDependsOn, priorityClasses...)))
 
 	if err != nil {
 		return nil, err
@@ -96,25 +115,11 @@ func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Pr
 		},
 		OtherFields: map[string]interface{}{
 			"spec": map[string]interface{}{
-				"provider": map[string]interface{}{
-					"gcpsm": map[string]interface{}{
-						"projectID": pulumi.String(projectID),
-						"auth": map[string]interface{}{
-							"workloadIdentity": map[string]interface{}{
-								"serviceAccountRef": map[string]interface{}{
-									"name":      "external-secrets-ksa",
-									"namespace": "external-secrets",
-								},
-								"clusterLocation": pulumi.String(clusterLocation),
-								"clusterName":     pulumi.String(clusterName),
-							},
-						},
-					},
-				},
+				"provider": backend.provider,
 			},
 		},
 	}, pulumi.Provider(kubeProvider),
-		pulumi.DependsOn([]pulumi.Resource{SecretsProvider, ns}))
+		pulumi.DependsOn(append([]pulumi.Resource{SecretsProvider, ns}, backend.dependsOn...)))
 
 	if err != nil {
 		return nil, err
@@ -124,7 +129,12 @@ func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Pr
 	ctx.Export("externalSecret", clusterSecretStore.URN())
 
 	// Create External Secrets for passwords
-	err = ManagePasswordSecrets(ctx, kubeProvider, clusterSecretStore)
+	err = ManagePasswordSecrets(ctx, kubeProvider, clusterSecretStore, nodeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createSecretConsumers(ctx, kubeProvider, clusterSecretStore, nodeConfig, backendSecrets)
 	if err != nil {
 		return nil, err
 	}
@@ -133,27 +143,27 @@ func InstallSecretsDependencies(ctx *pulumi.Context, kubeProvider *kubernetes.Pr
 }
 
 // If we're not using roles, use a service account key
-func CreateESSecrets(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) error {
+func CreateESSecrets(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, serviceAccountKey pulumi.StringOutput, ns *corev1.Namespace) (*corev1.Secret, error) {
 	// Create a secret to store the GCP service account key
-	_, err := corev1.NewSecret(ctx, "secret-service-account-key", &corev1.SecretArgs{
+	secret, err := corev1.NewSecret(ctx, "secret-service-account-key", &corev1.SecretArgs{
 		Metadata: &metav1.ObjectMetaArgs{
 			Name:      pulumi.String("secret-service-account-key"),
 			Namespace: pulumi.String("external-secrets"),
 		},
 		StringData: pulumi.StringMap{
-			"KEYID":     pulumi.String(""),
-			"SECRETKEY": pulumi.String(""),
+			"secret-access-credentials": serviceAccountKey,
 		},
 		Type: pulumi.String("Opaque"),
-	}, pulumi.Provider(kubeProvider))
+	}, pulumi.Provider(kubeProvider),
+		pulumi.DependsOn([]pulumi.Resource{ns}))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return secret, nil
 }
 
-func CreateGSA(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, projectID string) (gsa *serviceaccount.Account, err error) {
+func CreateGSA(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, projectID string, secretIDs []string, secrets []pulumi.Resource) (gsa *serviceaccount.Account, err error) {
 	// Create the service account
 	gsa, err = serviceaccount.NewAccount(ctx, "secret-account", &serviceaccount.AccountArgs{
 		AccountId: pulumi.String(ServiceAccountName),
@@ -174,69 +184,85 @@ func CreateGSA(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, projectID
 		return nil, err
 	}
 
-	// Grant Secret Manager access at the project level using IAM binding instead of member
-	_, err = projects.NewIAMBinding(ctx, "secret-accessor-binding", &projects.IAMBindingArgs{
-		Project: pulumi.String(projectID),
-		Role:    pulumi.String("roles/secretmanager.secretAccessor"),
-		Members: pulumi.StringArray{
-			gsa.Email.ApplyT(func(email string) string {
-				return fmt.Sprintf("serviceAccount:%s", email)
-			}).(pulumi.StringOutput),
-		},
-	})
+	// Access is granted per secret with members, which leave everyone else on the secret and in the project alone.
+	// A secret has to exist before it can be granted on, so secretIDs are only the ones pulumi creates from the secrets manifest.
+	for _, secretID := range secretIDs {
+		_, err = secretmanager.NewSecretIamMember(ctx, fmt.Sprintf("secret-accessor-%s", secretID), &secretmanager.SecretIamMemberArgs{
+			Project:  pulumi.String(projectID),
+			SecretId: pulumi.String(secretID),
+			Role:     pulumi.String("roles/secretmanager.secretAccessor"),
+			Member:   pulumi.Sprintf("serviceAccount:%s", gsa.Email),
+		}, pulumi.DependsOn(secrets))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gsa, nil
+}
+
+// CreateESRole creates the IAM role external-secrets-ksa assumes through IRSA on EKS.
+// It can read and describe the secrets in secretIDs, in the stack's account and region, nothing else.
+func CreateESRole(ctx *pulumi.Context, oidcProvider *iam.OpenIdConnectProvider, region string, secretIDs []string) (*iam.Role, error) {
+	assumeRolePolicy, err := irsaAssumeRolePolicy(oidcProvider, "external-secrets", "external-secrets-ksa")
 	if err != nil {
 		return nil, err
 	}
 
-	// Grant Secret Manager viewer role
-	_, err = projects.NewIAMBinding(ctx, "secret-viewer-binding", &projects.IAMBindingArgs{
-		Project: pulumi.String(projectID),
-		Role:    pulumi.String("roles/secretmanager.viewer"),
-		Members: pulumi.StringArray{
-			gsa.Email.ApplyT(func(email string) string {
-				return fmt.Sprintf("serviceAccount:%s", email)
-			}).(pulumi.StringOutput),
-		},
+	role, err := iam.NewRole(ctx, "external-secrets-role", &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicy,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return gsa, nil
-}
+	identity, err := aws.GetCallerIdentity(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
 
-func CreateKSA(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, gsaEmail pulumi.StringOutput, ns *corev1.Namespace) (ksa *corev1.ServiceAccount, err error) {
-	// Create a Kubernetes Service Account
-	ksa, err = corev1.NewServiceAccount(ctx, "secret-service-account", &corev1.ServiceAccountArgs{
-		Metadata: &metav1.ObjectMetaArgs{
-			Namespace: pulumi.String("external-secrets"),
-			Name:      pulumi.String("external-secrets-ksa"),
-			Annotations: pulumi.StringMap{
-				"iam.gke.io/gcp-service-account": gsaEmail,
+	// Secrets Manager appends six random characters to every secret's ARN
+	var resources []string
+	for _, secretID := range secretIDs {
+		resources = append(resources, fmt.Sprintf("arn:aws:secretsmanager:%s:%s:secret:%s-??????", region, identity.AccountId, secretID))
+	}
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect": "Allow",
+				"Action": []string{
+					"secretsmanager:GetSecretValue",
+					"secretsmanager:DescribeSecret",
+					"secretsmanager:ListSecretVersionIds",
+				},
+				"Resource": resources,
 			},
 		},
-	}, pulumi.Provider(kubeProvider),
-		pulumi.DependsOn([]pulumi.Resource{ns}))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "external-secrets-policy", &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: pulumi.String(string(policy)),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a Kubernetes ClusterRoleBinding
-	_, err = rbacv1.NewClusterRoleBinding(ctx, "secret-service-account-binding", &rbacv1.ClusterRoleBindingArgs{
+	return role, nil
+}
+
+func CreateKSA(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, annotations pulumi.StringMap, ns *corev1.Namespace) (ksa *corev1.ServiceAccount, err error) {
+	// Create a Kubernetes Service Account
+	ksa, err = corev1.NewServiceAccount(ctx, "secret-service-account", &corev1.ServiceAccountArgs{
 		Metadata: &metav1.ObjectMetaArgs{
-			Name: pulumi.String("secret-service-account-binding"),
-		},
-		RoleRef: &rbacv1.RoleRefArgs{
-			ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
-			Kind:     pulumi.String("ClusterRole"),
-			Name:     pulumi.String("cluster-admin"), // Adjust as needed for appropriate permissions
-		},
-		Subjects: rbacv1.SubjectArray{
-			&rbacv1.SubjectArgs{
-				Kind:      pulumi.String("ServiceAccount"),
-				Name:      ksa.Metadata.Name().Elem(),
-				Namespace: pulumi.String("external-secrets"),
-			},
+			Namespace:   pulumi.String("external-secrets"),
+			Name:        pulumi.String("external-secrets-ksa"),
+			Annotations: annotations,
 		},
 	}, pulumi.Provider(kubeProvider),
 		pulumi.DependsOn([]pulumi.Resource{ns}))
@@ -0,0 +1,184 @@
+package dependencies
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Every backend authenticates as the service account the external-secrets chart runs as
+var externalSecretsServiceAccountRef = map[string]interface{}{
+	"name":      "external-secrets-ksa",
+	"namespace": "external-secrets",
+}
+
+// secretsBackend is the part of the external-secrets install that depends on where the secrets live.
+// Whichever backend is used, the store is always cluster-secret-store, so the apps' ExternalSecrets don't change.
+type secretsBackend struct {
+	// annotations go on external-secrets-ksa
+	annotations pulumi.StringMap
+	// provider is the ClusterSecretStore spec.provider block
+	provider map[string]interface{}
+	// dependsOn is anything the store needs before it can authenticate
+	dependsOn []pulumi.Resource
+}
+
+// newSecretsBackend sets up the credentials for the stack's secrets-backend. oidcProvider is the EKS cluster's, for IRSA.
+// values and backendSecrets are the secrets manifest's values and the backend secrets created for them.
+func newSecretsBackend(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, oidcProvider *iam.OpenIdConnectProvider, ns *corev1.Namespace, values map[string]pulumi.StringOutput, backendSecrets map[string]pulumi.Resource) (*secretsBackend, error) {
+	switch nodeConfig.SecretsBackend {
+	case "gcpsm":
+		return newGCPSMBackend(ctx, kubeProvider, nodeConfig, ns, backendSecrets)
+	case "aws":
+		return newAWSSecretsManagerBackend(ctx, nodeConfig, oidcProvider)
+	case "vault":
+		return newVaultBackend(nodeConfig), nil
+	case "fake":
+		return newFakeBackend(nodeConfig, values), nil
+	}
+
+	return nil, fmt.Errorf("secrets backend %s is not supported", nodeConfig.SecretsBackend)
+}
+
+// newGCPSMBackend reads GCP Secret Manager. Workload identity needs the GKE metadata server, so only clusters
+// we build on GKE get it by default, existing GKE clusters with workload identity enabled can opt in with
+// gcp-workload-identity. Everything else authenticates with the gcp-credentials service account key.
+func newGCPSMBackend(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, nodeConfig *utils.NodeConfig, ns *corev1.Namespace, backendSecrets map[string]pulumi.Resource) (*secretsBackend, error) {
+	backend := &secretsBackend{annotations: pulumi.StringMap{}}
+	var auth map[string]interface{}
+
+	if nodeConfig.GCPWorkloadIdentity {
+		gsa, err := CreateGSA(ctx, kubeProvider, nodeConfig.GCPProject, manifestSecretIDs(nodeConfig), inManifestOrder(nodeConfig, backendSecrets))
+		if err != nil {
+			return nil, err
+		}
+		backend.annotations["iam.gke.io/gcp-service-account"] = gsa.Email
+
+		auth = map[string]interface{}{
+			"workloadIdentity": map[string]interface{}{
+				"serviceAccountRef": externalSecretsServiceAccountRef,
+				"clusterLocation":   pulumi.String(nodeConfig.Location),
+				"clusterName":       pulumi.String(nodeConfig.ClusterName),
+			},
+		}
+	} else {
+		keySecret, err := CreateESSecrets(ctx, kubeProvider, *nodeConfig.GCPCredentials, ns)
+		if err != nil {
+			return nil, err
+		}
+		backend.dependsOn = append(backend.dependsOn, keySecret)
+
+		auth = map[string]interface{}{
+			"secretRef": map[string]interface{}{
+				"secretAccessKeySecretRef": map[string]interface{}{
+					"name":      "secret-service-account-key",
+					"key":       "secret-access-credentials",
+					"namespace": "external-secrets",
+				},
+			},
+		}
+	}
+
+	backend.provider = map[string]interface{}{
+		"gcpsm": map[string]interface{}{
+			"projectID": pulumi.String(nodeConfig.GCPProject),
+			"auth":      auth,
+		},
+	}
+
+	return backend, nil
+}
+
+// newAWSSecretsManagerBackend reads AWS Secrets Manager in the stack's region through IRSA.
+// The role is created for the cluster unless aws-secrets-role-arn brings one.
+func newAWSSecretsManagerBackend(ctx *pulumi.Context, nodeConfig *utils.NodeConfig, oidcProvider *iam.OpenIdConnectProvider) (*secretsBackend, error) {
+	roleArn := pulumi.String(nodeConfig.AWSSecretsRoleArn).ToStringOutput()
+	if nodeConfig.AWSSecretsRoleArn == "" {
+		role, err := CreateESRole(ctx, oidcProvider, nodeConfig.Region, nodeConfig.SecretIDs())
+		if err != nil {
+			return nil, err
+		}
+		roleArn = role.Arn
+	}
+
+	return &secretsBackend{
+		annotations: pulumi.StringMap{
+			"eks.amazonaws.com/role-arn": roleArn,
+		},
+		provider: map[string]interface{}{
+			"aws": map[string]interface{}{
+				"service": "SecretsManager",
+				"region":  pulumi.String(nodeConfig.Region),
+				"auth": map[string]interface{}{
+					"jwt": map[string]interface{}{
+						"serviceAccountRef": externalSecretsServiceAccountRef,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// newVaultBackend reads a Vault KV engine, logging in with Vault's Kubernetes auth method.
+// The Vault side (auth mount, role and its policy) is expected to exist already.
+func newVaultBackend(nodeConfig *utils.NodeConfig) *secretsBackend {
+	vault := nodeConfig.Vault
+	return &secretsBackend{
+		annotations: pulumi.StringMap{},
+		provider: map[string]interface{}{
+			"vault": map[string]interface{}{
+				"server":  pulumi.String(vault.Server),
+				"path":    pulumi.String(vault.Path),
+				"version": pulumi.String(vault.Version),
+				"auth": map[string]interface{}{
+					"kubernetes": map[string]interface{}{
+						"mountPath":         pulumi.String(vault.MountPath),
+						"role":              pulumi.String(vault.Role),
+						"serviceAccountRef": externalSecretsServiceAccountRef,
+					},
+				},
+			},
+		},
+	}
+}
+
+// newFakeBackend serves fake-secrets and the secrets manifest's values straight from the store,
+// for dev clusters with no secret manager to reach
+func newFakeBackend(nodeConfig *utils.NodeConfig, values map[string]pulumi.StringOutput) *secretsBackend {
+	allValues := map[string]pulumi.StringInput{}
+	for key, value := range nodeConfig.FakeSecrets {
+		allValues[key] = pulumi.String(value)
+	}
+	for key, value := range values {
+		allValues[key] = value
+	}
+
+	// Sorted so the store doesn't show a diff on every run
+	keys := make([]string, 0, len(allValues))
+	for key := range allValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := pulumi.Array{}
+	for _, key := range keys {
+		data = append(data, pulumi.Map{
+			"key":   pulumi.String(key),
+			"value": allValues[key],
+		})
+	}
+
+	return &secretsBackend{
+		annotations: pulumi.StringMap{},
+		provider: map[string]interface{}{
+			"fake": map[string]interface{}{
+				"data": pulumi.ToSecret(data),
+			},
+		},
+	}
+}
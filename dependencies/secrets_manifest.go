@@ -0,0 +1,163 @@
+package dependencies
+
+import (
+	"fmt"
+
+	"github.com/dimo/dimo-node/utils"
+	awssecretsmanager "github.com/pulumi/pulumi-aws/sdk/v6/go/aws/secretsmanager"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/secretmanager"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Written to placeholder secrets, whoever owns the real value replaces it in the backend
+const placeholderSecretValue = "placeholder"
+
+// secretValues generates or reads the value of every secret in the secrets manifest, keyed by remote key
+func secretValues(ctx *pulumi.Context, nodeConfig *utils.NodeConfig) (map[string]pulumi.StringOutput, error) {
+	values := map[string]pulumi.StringOutput{}
+	for _, secret := range nodeConfig.Secrets {
+		switch secret.Generator {
+		case "random":
+			password, err := random.NewRandomPassword(ctx, fmt.Sprintf("secret-%s", secret.Name), &random.RandomPasswordArgs{
+				Length:  pulumi.Int(secret.Length),
+				Special: pulumi.Bool(secret.Special),
+			})
+			if err != nil {
+				return nil, err
+			}
+			values[secret.Name] = password.Result
+		case "imported":
+			values[secret.Name] = pulumi.ToSecret(pulumi.String(secret.Value)).(pulumi.StringOutput)
+		case "placeholder":
+			values[secret.Name] = pulumi.String(placeholderSecretValue).ToStringOutput()
+		default:
+			return nil, fmt.Errorf("secret %s generator %s is not supported", secret.Name, secret.Generator)
+		}
+	}
+
+	return values, nil
+}
+
+// createBackendSecrets writes the manifest's secrets to the backend and returns, per remote key, the resource
+// that holds its value. The fake backend serves the values from the store itself, so nothing is created for it.
+func createBackendSecrets(ctx *pulumi.Context, nodeConfig *utils.NodeConfig, values map[string]pulumi.StringOutput) (map[string]pulumi.Resource, error) {
+	created := map[string]pulumi.Resource{}
+	for _, secret := range nodeConfig.Secrets {
+		var version pulumi.Resource
+		var err error
+		switch nodeConfig.SecretsBackend {
+		case "gcpsm":
+			version, err = createGCPSecret(ctx, nodeConfig.GCPProject, secret, values[secret.Name])
+		case "aws":
+			version, err = createAWSSecret(ctx, secret, values[secret.Name])
+		case "fake":
+			continue
+		default:
+			return nil, fmt.Errorf("secrets can't be created in the %s secrets backend", nodeConfig.SecretsBackend)
+		}
+		if err != nil {
+			return nil, err
+		}
+		created[secret.Name] = version
+	}
+
+	return created, nil
+}
+
+// inManifestOrder lists the created backend secrets in the order the manifest declares them
+func inManifestOrder(nodeConfig *utils.NodeConfig, backendSecrets map[string]pulumi.Resource) []pulumi.Resource {
+	var ordered []pulumi.Resource
+	for _, secret := range nodeConfig.Secrets {
+		if backendSecret, exists := backendSecrets[secret.Name]; exists {
+			ordered = append(ordered, backendSecret)
+		}
+	}
+	return ordered
+}
+
+// manifestSecretIDs are the remote keys of the secrets manifest, the backend secrets pulumi creates
+func manifestSecretIDs(nodeConfig *utils.NodeConfig) []string {
+	var ids []string
+	for _, secret := range nodeConfig.Secrets {
+		ids = append(ids, secret.Name)
+	}
+	return ids
+}
+
+func createGCPSecret(ctx *pulumi.Context, projectID string, secret utils.SecretSpec, value pulumi.StringOutput) (pulumi.Resource, error) {
+	gcpSecret, err := secretmanager.NewSecret(ctx, fmt.Sprintf("secret-%s", secret.Name), &secretmanager.SecretArgs{
+		Project:  pulumi.String(projectID),
+		SecretId: pulumi.String(secret.Name),
+		Replication: &secretmanager.SecretReplicationArgs{
+			Auto: &secretmanager.SecretReplicationAutoArgs{},
+		},
+		Labels: pulumi.StringMap{
+			"generator": pulumi.String(secret.Generator),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions added outside of pulumi become the latest one, which is what external-secrets reads
+	return secretmanager.NewSecretVersion(ctx, fmt.Sprintf("secret-%s-version", secret.Name), &secretmanager.SecretVersionArgs{
+		Secret:     gcpSecret.ID(),
+		SecretData: value,
+	})
+}
+
+func createAWSSecret(ctx *pulumi.Context, secret utils.SecretSpec, value pulumi.StringOutput) (pulumi.Resource, error) {
+	awsSecret, err := awssecretsmanager.NewSecret(ctx, fmt.Sprintf("secret-%s", secret.Name), &awssecretsmanager.SecretArgs{
+		Name: pulumi.String(secret.Name),
+		Tags: pulumi.StringMap{
+			"generator": pulumi.String(secret.Generator),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A placeholder's real value moves AWSCURRENT off our version, which is expected
+	var opts []pulumi.ResourceOption
+	if secret.Generator == "placeholder" {
+		opts = append(opts, pulumi.IgnoreChanges([]string{"versionStages"}))
+	}
+
+	return awssecretsmanager.NewSecretVersion(ctx, fmt.Sprintf("secret-%s-version", secret.Name), &awssecretsmanager.SecretVersionArgs{
+		SecretId:     awsSecret.ID(),
+		SecretString: value,
+	}, opts...)
+}
+
+// createSecretConsumers creates an ExternalSecret for every consumer in the secrets manifest,
+// once the store is up and the backend secret it reads has been written
+func createSecretConsumers(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, clusterSecretStore *apiextensions.CustomResource, nodeConfig *utils.NodeConfig, backendSecrets map[string]pulumi.Resource) error {
+	for _, secret := range nodeConfig.Secrets {
+		for _, consumer := range secret.Consumers {
+			namespaces, err := utils.CreateNamespaces(ctx, kubeProvider, []string{consumer.Namespace})
+			if err != nil {
+				return err
+			}
+
+			dependsOn := []pulumi.Resource{clusterSecretStore, namespaces[consumer.Namespace]}
+			if backendSecret, exists := backendSecrets[secret.Name]; exists {
+				dependsOn = append(dependsOn, backendSecret)
+			}
+
+			_, err = utils.NewExternalSecret(ctx, fmt.Sprintf("%s-%s-external-secret", consumer.Namespace, consumer.SecretName), kubeProvider, utils.ExternalSecretArgs{
+				Namespace:  consumer.Namespace,
+				SecretName: consumer.SecretName,
+				Data:       map[string]string{consumer.Key: secret.Name},
+				DependsOn:  dependsOn,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create external secret %s/%s: %v", consumer.Namespace, consumer.SecretName, err)
+			}
+		}
+	}
+
+	return nil
+}
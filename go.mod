@@ -10,13 +10,14 @@ replace github.com/dimo/dimo-node/dependencies => ./dependencies
 
 require (
 	cloud.google.com/go/secretmanager v1.14.2
-	github.com/joho/godotenv v1.5.1
 	github.com/pulumi/pulumi-command/sdk v0.9.2
 	github.com/pulumi/pulumi-kubernetes/sdk/v4 v4.19.0
 	github.com/pulumi/pulumi/sdk/v3 v3.143.0
+	golang.org/x/oauth2 v0.23.0
 	google.golang.org/api v0.203.0
 	k8s.io/apimachinery v0.32.0
 	k8s.io/client-go v0.32.0
+	sigs.k8s.io/yaml v1.4.0
 )
 
 require (
@@ -52,6 +53,7 @@ require (
 	github.com/nxadm/tail v1.4.11 // indirect
 	github.com/pgavlin/fx v0.1.6 // indirect
 	github.com/pulumi/appdash v0.0.0-20231130102222-75f619a67231 // indirect
+	github.com/pulumi/pulumi-random/sdk/v4 v4.16.7
 	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/x448/float16 v0.8.4 // indirect
 	go.opencensus.io v0.24.0 // indirect
@@ -60,7 +62,6 @@ require (
 	go.opentelemetry.io/otel v1.29.0 // indirect
 	go.opentelemetry.io/otel/metric v1.29.0 // indirect
 	go.opentelemetry.io/otel/trace v1.29.0 // indirect
-	golang.org/x/oauth2 v0.23.0 // indirect
 	golang.org/x/time v0.7.0 // indirect
 	google.golang.org/genproto v0.0.0-20241015192408-796eee8c2d53 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20241007155032-5fefd90f89a9 // indirect
@@ -73,7 +74,6 @@ require (
 	k8s.io/utils v0.0.0-20241104100929-3ea5e8cea738 // indirect
 	sigs.k8s.io/json v0.0.0-20241010143419-9aa6b5e7a4b3 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.4.2 // indirect
-	sigs.k8s.io/yaml v1.4.0 // indirect
 )
 
 require (
@@ -127,7 +127,13 @@ require (
 	github.com/pkg/term v1.1.0 // indirect
 	github.com/pulumi/esc v0.10.0 // indirect
 	github.com/pulumi/pulumi-aws/sdk/v6 v6.9.0
+	github.com/pulumi/pulumi-azure-native-sdk/authorization/v2 v2.73.1
+	github.com/pulumi/pulumi-azure-native-sdk/containerservice/v2 v2.73.1
+	github.com/pulumi/pulumi-azure-native-sdk/network/v2 v2.73.1
+	github.com/pulumi/pulumi-azure-native-sdk/resources/v2 v2.73.1
+	github.com/pulumi/pulumi-azure-native-sdk/v2 v2.73.1 // indirect
 	github.com/pulumi/pulumi-gcp/sdk/v7 v7.38.0
+	github.com/pulumi/pulumi-tls/sdk/v4 v4.11.1
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/rogpeppe/go-internal v1.12.0 // indirect
 	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06 // indirect
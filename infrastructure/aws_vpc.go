@@ -3,33 +3,35 @@ package infrastructure
 import (
 	"fmt"
 
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-// Define variables needed outside the buildInfrastructure() function
-var vpcId pulumi.StringInput
-var publicSubnets pulumi.StringArray
-var privateSubnets pulumi.StringArray
-
 // Builds base infrastructure when called
-func buildAWSNetworking(ctx *pulumi.Context) (err error) {
-	fmt.Println("Building AWS Networking")
+func buildAWSNetworking(ctx *pulumi.Context, projectName string, ruleSets []utils.FirewallRuleSet) (*NetworkResult, error) {
+	ctx.Log.Info("Building AWS networking", nil)
+	var publicSubnets pulumi.StringArray
+	var privateSubnets pulumi.StringArray
+
+	// Tag everything with the cluster name so the AWS load balancer integration can discover the subnets
+	clusterTag := fmt.Sprintf("kubernetes.io/cluster/%s", projectName)
+
 	// Look up AZ information for configured region and gather details
 	desiredAzState := "available"
 	rawAzInfo, err := aws.GetAvailabilityZones(ctx, &aws.GetAvailabilityZonesArgs{
 		State: &desiredAzState,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	numOfAzs := len(rawAzInfo.Names)
 
 	azNames := make([]string, pulumi.Int(numOfAzs))
 	for i := 0; i < numOfAzs; i++ {
 		azNames[i] = rawAzInfo.Names[i]
-		fmt.Println("AZ Name: ", azNames[i])
+		ctx.Log.Debug(fmt.Sprintf("AZ name: %s", azNames[i]), nil)
 	}
 
 	// Create a new VPC and make the ID accessible outside the function
@@ -39,34 +41,33 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 		EnableDnsSupport:   pulumi.Bool(true),
 
 		Tags: pulumi.StringMap{
-			"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
+			clusterTag: pulumi.String("shared"),
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	vpcId = vpc.ID()
 
 	// Create an Internet gateway
 	inetGw, err := ec2.NewInternetGateway(ctx, "inet-gw", &ec2.InternetGatewayArgs{
 		VpcId: vpc.ID(),
 		Tags: pulumi.StringMap{
-			"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
+			clusterTag: pulumi.String("shared"),
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Adopt the default route in the VPC
 	defRoute, err := ec2.NewDefaultRouteTable(ctx, "def-route", &ec2.DefaultRouteTableArgs{
 		DefaultRouteTableId: vpc.DefaultRouteTableId,
 		Tags: pulumi.StringMap{
-			"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
+			clusterTag: pulumi.String("shared"),
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Associate gateway with default route
@@ -76,7 +77,7 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 		GatewayId:            inetGw.ID(),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create public subnets
@@ -89,12 +90,12 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 			CidrBlock:           pulumi.String(subnetCidrBlock),
 			MapPublicIpOnLaunch: pulumi.Bool(true),
 			Tags: pulumi.StringMap{
-				"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
-				"kubernetes.io/role/elb":            pulumi.String("1"),
+				clusterTag:               pulumi.String("shared"),
+				"kubernetes.io/role/elb": pulumi.String("1"),
 			},
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		publicSubnets = append(publicSubnets, subnet.ID())
 	}
@@ -103,11 +104,11 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 	eip, err := ec2.NewEip(ctx, "eip", &ec2.EipArgs{
 		Domain: pulumi.String("vpc"),
 		Tags: pulumi.StringMap{
-			"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
+			clusterTag: pulumi.String("shared"),
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create a NAT Gateway for the private subnets
@@ -115,11 +116,11 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 		AllocationId: eip.ID(),
 		SubnetId:     publicSubnets[0],
 		Tags: pulumi.StringMap{
-			"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
+			clusterTag: pulumi.String("shared"),
 		},
 	}, pulumi.DependsOn([]pulumi.Resource{eip}))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create a route for the NAT Gateway
@@ -132,11 +133,11 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 			},
 		},
 		Tags: pulumi.StringMap{
-			"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
+			clusterTag: pulumi.String("shared"),
 		},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create private subnets
@@ -149,12 +150,12 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 			CidrBlock:           pulumi.String(subnetCidrBlock),
 			MapPublicIpOnLaunch: pulumi.Bool(false),
 			Tags: pulumi.StringMap{
-				"kubernetes.io/cluster/testcluster": pulumi.String("shared"),
-				"kubernetes.io/role/internal-elb":   pulumi.String("1"),
+				clusterTag:                        pulumi.String("shared"),
+				"kubernetes.io/role/internal-elb": pulumi.String("1"),
 			},
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		privateSubnets = append(privateSubnets, subnet.ID())
 	}
@@ -166,10 +167,49 @@ func buildAWSNetworking(ctx *pulumi.Context) (err error) {
 			RouteTableId: natRoute.ID(),
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	// Create the whitelist security group, the AWS equivalent of the GCP whitelist firewall
+	whitelistIngress, err := awsWhitelistIngress(ruleSets)
+	if err != nil {
+		return nil, err
+	}
+
+	whitelistSg, err := ec2.NewSecurityGroup(ctx, "whitelist-sg", &ec2.SecurityGroupArgs{
+		VpcId:       vpc.ID(),
+		Description: pulumi.String("Allow whitelisted access to the DIMO node"),
+		Ingress:     whitelistIngress,
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Tags: pulumi.StringMap{
+			clusterTag: pulumi.String("shared"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Rule sets naming their own security groups are added to those groups instead
+	if err := createAWSTargetedRules(ctx, ruleSets); err != nil {
+		return nil, err
+	}
+
+	ctx.Export("vpcId", vpc.ID())
+	ctx.Export("whitelistSecurityGroupId", whitelistSg.ID())
+
 	// Return to the calling function
-	return nil
+	return &NetworkResult{
+		NetworkId:        vpc.ID().ToStringOutput(),
+		PublicSubnetIds:  publicSubnets,
+		PrivateSubnetIds: privateSubnets,
+		FirewallIds:      pulumi.StringArray{whitelistSg.ID()},
+	}, nil
 }
@@ -0,0 +1,84 @@
+package infrastructure
+
+import (
+	"fmt"
+
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-azure-native-sdk/network/v2"
+	"github.com/pulumi/pulumi-azure-native-sdk/resources/v2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// azureIngressRule lets the internet reach ingress-nginx through its load balancer, after the firewall rule sets
+var azureIngressRule = network.SecurityRuleTypeArgs{
+	Name:                     pulumi.String("allow-ingress"),
+	Priority:                 pulumi.Int(1000),
+	Direction:                pulumi.String("Inbound"),
+	Access:                   pulumi.String("Allow"),
+	Protocol:                 pulumi.String("Tcp"),
+	SourceAddressPrefix:      pulumi.String("Internet"),
+	SourcePortRange:          pulumi.String("*"),
+	DestinationAddressPrefix: pulumi.String("*"),
+	DestinationPortRanges:    pulumi.ToStringArray([]string{"80", "443"}),
+}
+
+// Builds the resource group, VNet and subnet the AKS cluster lives in
+func buildAzureNetworking(ctx *pulumi.Context, region string, projectName string, ruleSets []utils.FirewallRuleSet) (*NetworkResult, error) {
+	ctx.Log.Info("Building Azure networking", nil)
+
+	// Everything for the stack goes in one resource group so it can be torn down together
+	resourceGroup, err := resources.NewResourceGroup(ctx, fmt.Sprintf("%s-rg", projectName), &resources.ResourceGroupArgs{
+		Location: pulumi.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Network security group applying the firewall rule sets, attached to the subnet. The subnet's NSG also
+	// filters traffic through the ingress load balancer, so the ingress ports stay open to the internet.
+	nsg, err := network.NewNetworkSecurityGroup(ctx, fmt.Sprintf("%s-nsg", projectName), &network.NetworkSecurityGroupArgs{
+		ResourceGroupName: resourceGroup.Name,
+		Location:          resourceGroup.Location,
+		SecurityRules:     append(azureSecurityRules(ruleSets), azureIngressRule),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Subnets are managed as their own resources, so don't let the VNet fight over them
+	vnet, err := network.NewVirtualNetwork(ctx, fmt.Sprintf("%s-vnet", projectName), &network.VirtualNetworkArgs{
+		ResourceGroupName: resourceGroup.Name,
+		Location:          resourceGroup.Location,
+		AddressSpace: &network.AddressSpaceArgs{
+			AddressPrefixes: pulumi.StringArray{
+				pulumi.String("10.0.0.0/16"),
+			},
+		},
+	}, pulumi.IgnoreChanges([]string{"subnets"}))
+	if err != nil {
+		return nil, err
+	}
+
+	subnet, err := network.NewSubnet(ctx, fmt.Sprintf("%s-subnet", projectName), &network.SubnetArgs{
+		ResourceGroupName:  resourceGroup.Name,
+		VirtualNetworkName: vnet.Name,
+		AddressPrefix:      pulumi.String("10.0.0.0/20"),
+		NetworkSecurityGroup: &network.NetworkSecurityGroupTypeArgs{
+			Id: nsg.ID(),
+		},
+	}, pulumi.IgnoreChanges([]string{"routeTable"})) // kubenet attaches the route table AKS creates, which we don't manage
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Export("resourceGroupName", resourceGroup.Name)
+	ctx.Export("vnetName", vnet.Name)
+
+	return &NetworkResult{
+		NetworkId:         vnet.ID().ToStringOutput(),
+		PublicSubnetIds:   pulumi.StringArray{subnet.ID()},
+		PrivateSubnetIds:  pulumi.StringArray{subnet.ID()},
+		FirewallIds:       pulumi.StringArray{nsg.ID()},
+		ResourceGroupName: resourceGroup.Name,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package infrastructure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ClusterSettings holds the stack settings shared by every ClusterProvider
+type ClusterSettings struct {
+	CloudProvider   string
+	ProjectName     string
+	Region          string
+	Location        string
+	Locations       []string
+	FirewallRules   []utils.FirewallRuleSet
+	CreateNodePools bool
+	Ingress         IngressSettings
+	GKE             GKESettings
+	EKS             EKSSettings
+	AKS             AKSSettings
+	K3s             K3sSettings
+	Existing        ExistingClusterSettings
+	Local           LocalClusterSettings
+}
+
+// ClusterProvider builds everything needed to get a working Kubernetes provider for one deployment type.
+// BuildInfrastructure calls the methods in the order they are declared here, and each implementation
+// keeps whatever state it needs between calls (network, cluster, instances...) on itself.
+type ClusterProvider interface {
+	// CreateNetwork creates the network the cluster will live in
+	CreateNetwork(ctx *pulumi.Context) error
+	// CreateCluster creates the cluster (or VM) itself
+	CreateCluster(ctx *pulumi.Context) error
+	// CreateNodePools creates additional node pools, only called when create-node-pools is set
+	CreateNodePools(ctx *pulumi.Context) error
+	// GetKubeProvider returns a Kubernetes provider for the cluster
+	GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error)
+}
+
+// ingressAddressReserver is implemented by the cluster providers that reserve an address for the ingress load balancer
+type ingressAddressReserver interface {
+	// IngressAddress returns the reserved address, nil when ingress-static-ip is off
+	IngressAddress() *IngressAddress
+}
+
+// oidcIssuer is implemented by the cluster providers whose service accounts can assume IAM roles (IRSA)
+type oidcIssuer interface {
+	// OidcProvider returns the IAM OIDC provider for the cluster's service account token issuer
+	OidcProvider() *iam.OpenIdConnectProvider
+}
+
+// ClusterProviderFactory creates a ClusterProvider from the stack settings
+type ClusterProviderFactory func(settings ClusterSettings) ClusterProvider
+
+var clusterProviders = map[string]ClusterProviderFactory{}
+
+// RegisterClusterProvider makes a ClusterProvider available under a deployment-type config value.
+// Providers register themselves from an init() function in their own file.
+func RegisterClusterProvider(deploymentType string, factory ClusterProviderFactory) {
+	if _, exists := clusterProviders[deploymentType]; exists {
+		panic(fmt.Sprintf("cluster provider for deployment type %s already registered", deploymentType))
+	}
+	clusterProviders[deploymentType] = factory
+}
+
+// GetClusterProvider returns the ClusterProvider registered for the deployment type
+func GetClusterProvider(deploymentType string, settings ClusterSettings) (ClusterProvider, error) {
+	factory, exists := clusterProviders[deploymentType]
+	if !exists {
+		return nil, fmt.Errorf("deployment type %s not supported (available: %s)", deploymentType, strings.Join(ClusterProviderTypes(), ", "))
+	}
+
+	return factory(settings), nil
+}
+
+// ClusterProviderTypes returns the registered deployment types in sorted order
+func ClusterProviderTypes() []string {
+	types := make([]string, 0, len(clusterProviders))
+	for deploymentType := range clusterProviders {
+		types = append(types, deploymentType)
+	}
+	sort.Strings(types)
+
+	return types
+}
@@ -0,0 +1,185 @@
+package infrastructure
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	azurenetwork "github.com/pulumi/pulumi-azure-native-sdk/network/v2"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/compute"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createGCPFirewalls creates one firewall per rule set, targeting the node VM tag unless the set names its own.
+// The first set takes over the single <project>-firewall stacks were created with, it is only narrowed
+// once the other sets exist so the ports it used to open are never left closed.
+func createGCPFirewalls(ctx *pulumi.Context, projectName string, network *compute.Network, ruleSets []utils.FirewallRuleSet, opts ...pulumi.ResourceOption) (pulumi.StringArray, pulumi.StringArray, error) {
+	firewalls := make([]*compute.Firewall, len(ruleSets))
+	var others []pulumi.Resource
+
+	for i := len(ruleSets) - 1; i >= 0; i-- {
+		ruleSet := ruleSets[i]
+		allow := &compute.FirewallAllowArgs{
+			Protocol: pulumi.String(ruleSet.Protocol),
+		}
+		if ruleSet.HasPorts() {
+			allow.Ports = pulumi.ToStringArray(ruleSet.Ports)
+		}
+
+		targetTags := pulumi.StringArray{instanceTag}
+		if len(ruleSet.TargetTags) > 0 {
+			targetTags = pulumi.ToStringArray(ruleSet.TargetTags)
+		}
+
+		firewallOpts := opts
+		if i == 0 {
+			firewallOpts = append(slices.Clone(opts),
+				pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(fmt.Sprintf("%s-firewall", projectName))}}),
+				pulumi.DependsOn(others))
+		}
+
+		firewall, err := compute.NewFirewall(ctx, fmt.Sprintf("%s-%s", projectName, ruleSet.Name), &compute.FirewallArgs{
+			Network:      network.Name,
+			Allows:       compute.FirewallAllowArray{allow},
+			Direction:    pulumi.String("INGRESS"),
+			SourceRanges: pulumi.ToStringArray(ruleSet.SourceCidrs),
+			TargetTags:   targetTags,
+		}, firewallOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		firewalls[i] = firewall
+		others = append(others, firewall)
+	}
+
+	firewallIds := pulumi.StringArray{}
+	firewallNames := pulumi.StringArray{}
+	for _, firewall := range firewalls {
+		firewallIds = append(firewallIds, firewall.ID())
+		firewallNames = append(firewallNames, firewall.Name)
+	}
+
+	return firewallIds, firewallNames, nil
+}
+
+// awsProtocol maps a rule set protocol to the security group one, -1 being every protocol
+func awsProtocol(protocol string) string {
+	if protocol == "all" {
+		return "-1"
+	}
+	return protocol
+}
+
+// awsPortRanges returns the from/to pairs for a rule set, icmp and all cover every port with a single rule
+func awsPortRanges(ruleSet utils.FirewallRuleSet) ([][2]int, error) {
+	switch ruleSet.Protocol {
+	case "icmp":
+		return [][2]int{{-1, -1}}, nil
+	case "all":
+		return [][2]int{{0, 0}}, nil
+	}
+
+	var portRanges [][2]int
+	for _, port := range ruleSet.Ports {
+		from, to, err := utils.ParsePortRange(port)
+		if err != nil {
+			return nil, err
+		}
+		portRanges = append(portRanges, [2]int{from, to})
+	}
+
+	return portRanges, nil
+}
+
+// awsWhitelistIngress turns the rule sets without their own security groups into ingress on the whitelist group
+func awsWhitelistIngress(ruleSets []utils.FirewallRuleSet) (ec2.SecurityGroupIngressArray, error) {
+	ingress := ec2.SecurityGroupIngressArray{}
+
+	for _, ruleSet := range ruleSets {
+		if len(ruleSet.SecurityGroupIds) > 0 {
+			continue
+		}
+
+		portRanges, err := awsPortRanges(ruleSet)
+		if err != nil {
+			return nil, err
+		}
+		for _, portRange := range portRanges {
+			ingress = append(ingress, ec2.SecurityGroupIngressArgs{
+				Description: pulumi.String(ruleSet.Name),
+				Protocol:    pulumi.String(awsProtocol(ruleSet.Protocol)),
+				FromPort:    pulumi.Int(portRange[0]),
+				ToPort:      pulumi.Int(portRange[1]),
+				CidrBlocks:  pulumi.ToStringArray(ruleSet.SourceCidrs),
+			})
+		}
+	}
+
+	return ingress, nil
+}
+
+// createAWSTargetedRules adds the rule sets that name security groups to those groups as standalone rules.
+// The groups are managed outside this stack, so their inline rules can't be touched.
+func createAWSTargetedRules(ctx *pulumi.Context, ruleSets []utils.FirewallRuleSet) error {
+	for _, ruleSet := range ruleSets {
+		portRanges, err := awsPortRanges(ruleSet)
+		if err != nil {
+			return err
+		}
+
+		for _, securityGroupId := range ruleSet.SecurityGroupIds {
+			for _, portRange := range portRanges {
+				_, err := ec2.NewSecurityGroupRule(ctx, fmt.Sprintf("%s-%s-%d-%d", ruleSet.Name, securityGroupId, portRange[0], portRange[1]), &ec2.SecurityGroupRuleArgs{
+					Type:            pulumi.String("ingress"),
+					Description:     pulumi.String(ruleSet.Name),
+					SecurityGroupId: pulumi.String(securityGroupId),
+					Protocol:        pulumi.String(awsProtocol(ruleSet.Protocol)),
+					FromPort:        pulumi.Int(portRange[0]),
+					ToPort:          pulumi.Int(portRange[1]),
+					CidrBlocks:      pulumi.ToStringArray(ruleSet.SourceCidrs),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// azureProtocols maps the rule set protocols to the NSG ones
+var azureProtocols = map[string]string{
+	"tcp":  "Tcp",
+	"udp":  "Udp",
+	"icmp": "Icmp",
+	"all":  "*",
+}
+
+// azureSecurityRules turns each rule set into an inbound NSG rule, NSGs apply to the whole subnet so target tags are ignored
+func azureSecurityRules(ruleSets []utils.FirewallRuleSet) azurenetwork.SecurityRuleTypeArray {
+	rules := azurenetwork.SecurityRuleTypeArray{}
+
+	for i, ruleSet := range ruleSets {
+		destinationPorts := pulumi.StringArray{pulumi.String("*")}
+		if ruleSet.HasPorts() {
+			destinationPorts = pulumi.ToStringArray(ruleSet.Ports)
+		}
+
+		rules = append(rules, azurenetwork.SecurityRuleTypeArgs{
+			Name:                     pulumi.String(ruleSet.Name),
+			Priority:                 pulumi.Int(100 + i),
+			Direction:                pulumi.String("Inbound"),
+			Access:                   pulumi.String("Allow"),
+			Protocol:                 pulumi.String(azureProtocols[ruleSet.Protocol]),
+			SourceAddressPrefixes:    pulumi.ToStringArray(ruleSet.SourceCidrs),
+			SourcePortRange:          pulumi.String("*"),
+			DestinationAddressPrefix: pulumi.String("*"),
+			DestinationPortRanges:    destinationPorts,
+		})
+	}
+
+	return rules
+}
@@ -1,27 +1,16 @@
 package infrastructure
 
 import (
-	"strings"
-
 	"github.com/dimo/dimo-node/utils"
-	//"github.com/pulumi/pulumi-gcp/sdk/v5/go/gcp/compute"
-	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/compute"
-	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/container"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
 const osImage = "debian-11"
+const awsDebianOwner = "136693071363" // Debian's official AWS account
+const awsDebianImage = "debian-11-amd64-*"
 const sshUser = "pulumi"
-const pubKeyPath = "./infrastructure/keys/pulumi_key.pub"
-const privKeyPath = "./infrastructure/keys/pulumi_key"
-
-var firewallOpenPorts = []string{
-	"22",    // SSH
-	"6443",  // Kubernetes API
-	"31544", // Postgres Operator UI
-}
 
 // Configure what type of deployment and where it should be deployed
 // K3s (GCP)
@@ -48,143 +37,120 @@ const instanceTag = pulumi.String("dimo")
 // Configure your own access to the cluster or VM
 //const whitelistIp = pulumi.String("24.30.56.126/32")
 
-// Define variables needed outside the BuildInfrastructure() function
-var KubeConfig *pulumi.StringOutput
-var KubeProvider *kubernetes.Provider
-var Network *compute.Network
-var Subnetwork *compute.Subnetwork
-var Cluster *container.Cluster
-
-func BuildInfrastructure(ctx *pulumi.Context) (*kubernetes.Provider, error) {
-	conf := config.New(ctx, "")
-	cloudProvider := conf.Require("cloud-provider")
-	deploymentType := conf.Require("deployment-type")
-	// TODO: Set sane defaults for these if not set
-	projectName := conf.Get("project-name")
-	createNodePools := conf.GetBool("create-node-pools")
-	region := conf.Get("region")
-	location := conf.Get("location")
-	locationsStr := conf.Get("locations")
-	locations := strings.Split(locationsStr, ",")
-	whitelistIp := conf.Get("whitelist-ip")
-
-	network, subnetwork, err := CreateNetwork(ctx, cloudProvider, region, projectName, whitelistIp)
+// BuildResult is what the dependencies need from the infrastructure
+type BuildResult struct {
+	KubeProvider *kubernetes.Provider
+	// IngressAddress is the address reserved for the ingress load balancer, nil when none is reserved
+	IngressAddress *IngressAddress
+	// PriorityClasses are the priority tiers, anything naming a tier depends on them
+	PriorityClasses []pulumi.Resource
+	// OidcProvider lets Kubernetes service accounts assume IAM roles (IRSA), nil outside of EKS
+	OidcProvider *iam.OpenIdConnectProvider
+}
+
+func BuildInfrastructure(ctx *pulumi.Context, nodeConfig *utils.NodeConfig) (*BuildResult, error) {
+	clusterProvider, err := GetClusterProvider(nodeConfig.DeploymentType, ClusterSettings{
+		CloudProvider:   nodeConfig.CloudProvider,
+		ProjectName:     nodeConfig.ProjectName,
+		Region:          nodeConfig.Region,
+		Location:        nodeConfig.Location,
+		Locations:       nodeConfig.Locations,
+		FirewallRules:   nodeConfig.FirewallRules,
+		CreateNodePools: nodeConfig.CreateNodePools,
+		Ingress: IngressSettings{
+			Internal: nodeConfig.IngressLoadBalancer == "internal",
+			StaticIP: nodeConfig.IngressStaticIP,
+		},
+		GKE: GKESettings{
+			DefaultPool:           nodeConfig.GKEDefaultPool,
+			RemoveDefaultPool:     nodeConfig.GKERemoveDefaultPool,
+			NodePools:             nodeConfig.GKENodePools,
+			ClusterAutoscaling:    nodeConfig.GKEClusterAutoscaling,
+			PrivateCluster:        nodeConfig.GKEPrivateCluster,
+			PrivateEndpoint:       nodeConfig.GKEPrivateEndpoint,
+			MasterIpv4Cidr:        nodeConfig.GKEMasterIpv4Cidr,
+			MasterAuthorizedCidrs: nodeConfig.MasterAuthorizedCidrs,
+			Version:               nodeConfig.GKEVersion,
+			UpgradeSettings:       nodeConfig.UpgradeSettings,
+			MaintenanceWindow:     nodeConfig.MaintenanceWindow,
+			Credentials:           nodeConfig.GCPCredentials,
+			Project:               nodeConfig.GCPProject,
+		},
+		EKS: EKSSettings{
+			Version:          nodeConfig.EKSVersion,
+			DefaultNodeGroup: nodeConfig.EKSDefaultNodeGroup,
+			NodeGroups:       nodeConfig.EKSNodeGroups,
+			UpgradeSettings:  nodeConfig.UpgradeSettings,
+		},
+		AKS: AKSSettings{
+			SystemPool: nodeConfig.AKSSystemPool,
+			UserPool:   nodeConfig.AKSUserPool,
+		},
+		K3s: K3sSettings{
+			ServerCount:   nodeConfig.K3sServers,
+			AgentCount:    nodeConfig.K3sAgents,
+			HA:            nodeConfig.K3sHA,
+			SSHKeyVersion: nodeConfig.SSHKeyVersion,
+			Host:          nodeConfig.K3sHost,
+		},
+		Existing: ExistingClusterSettings{
+			Kubeconfig:     nodeConfig.Kubeconfig,
+			KubeconfigPath: nodeConfig.KubeconfigPath,
+			Context:        nodeConfig.KubeContext,
+		},
+		Local: LocalClusterSettings{
+			Registry:     nodeConfig.LocalRegistry,
+			RegistryPort: nodeConfig.LocalRegistryPort,
+			HttpPort:     nodeConfig.LocalHttpPort,
+			HttpsPort:    nodeConfig.LocalHttpsPort,
+		},
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	Network = network
-	Subnetwork = subnetwork
-
-	// TODO: Move this switch statement into its own file and call it with GetKubeProvider()
-	switch deploymentType {
-	case "k3s":
-		// Read the SSH Keys from Disk
-		pubKey, privKey, err := ReadSSHKeysFromDisk(pubKeyPath, privKeyPath)
-		if err != nil {
-			panic(err)
-		}
-
-		err = AddSSHKeysMetadata(ctx, cloudProvider, pubKey, privKey)
-		if err != nil {
-			return nil, err
-		}
-
-		inst, err := CreateK3sCluster(ctx, network, subnetwork)
-		if err != nil {
-			return nil, err
-		}
-
-		accessConfigs := inst.NetworkInterfaces.Index(pulumi.Int(0)).AccessConfigs()
-		publicIp := accessConfigs.Index(pulumi.Int(0)).NatIp().Elem()
-
-		connection, err := GetKubeHostConnection(ctx, publicIp, privKey)
-		if err != nil {
-			return nil, err
-		}
-
-		KubeConfig, err = GetKubeConfigForK3s(ctx, connection, inst)
-		if err != nil {
-			return nil, err
-		}
-
-		k3sProvider, err := NewKubeProviderForK3s(ctx, KubeConfig)
-		if err != nil {
-			return nil, err
-		}
-
-		// Note that .Elem() essentially dereferences the output pointer to give us an unwrapped value we can use
-		internalIp := inst.NetworkInterfaces.Index(pulumi.Int(0)).NetworkIp().Elem()
-
-		KubeProvider = k3sProvider
-
-		ctx.Export("instanceName", inst.Name)
-		ctx.Export("publicIp", publicIp)
-		ctx.Export("internalIp", internalIp)
-	case "gke":
-		cluster, err := CreateGKECluster(
-			ctx,
-			projectName,
-			region,
-			location,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if createNodePools {
-			err = CreateGKENodePools(ctx, projectName, cluster, region, locations)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		// Create the Kubernetes provider
-		k8sProvider, err := NewGKEKubernetesProvider(ctx, cluster)
-		if err != nil {
-			return nil, err
-		}
+	err = clusterProvider.CreateNetwork(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		err = CreateGKEKubePriorities(ctx, cluster, k8sProvider)
-		if err != nil {
-			return nil, err
-		}
+	err = clusterProvider.CreateCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		KubeProvider = k8sProvider
-	case "eks":
-		cluster, err := CreateEKSKubernetesCluster(
-			ctx,
-			projectName,
-			region,
-		)
+	if nodeConfig.CreateNodePools {
+		err = clusterProvider.CreateNodePools(ctx)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if createNodePools {
-			err = CreateEKSKubernetesNodePools(ctx, projectName, cluster, region)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		// Create the Kubernetes provider
-		k8sProvider, err := NewEKSKubernetesProvider(ctx, cluster)
-		if err != nil {
-			return nil, err
-		}
+	kubeProvider, err := clusterProvider.GetKubeProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		KubeProvider = k8sProvider
-	default:
+	// Every deployment type gets the same priority tiers
+	priorityClasses, err := CreatePriorityTiers(ctx, kubeProvider)
+	if err != nil {
 		return nil, err
 	}
 
-	ctx.Export("k8sProvider", KubeProvider.URN())
+	ctx.Export("k8sProvider", kubeProvider.URN())
 
-	_, err = utils.CreateNamespaces(ctx, KubeProvider, []string{"dimo"})
+	_, err = utils.CreateNamespaces(ctx, kubeProvider, []string{"dimo"})
 	if err != nil {
 		return nil, err
 	}
 
-	return KubeProvider, nil
+	result := &BuildResult{KubeProvider: kubeProvider, PriorityClasses: priorityClasses}
+	if reserver, ok := clusterProvider.(ingressAddressReserver); ok {
+		result.IngressAddress = reserver.IngressAddress()
+	}
+	if issuer, ok := clusterProvider.(oidcIssuer); ok {
+		result.OidcProvider = issuer.OidcProvider()
+	}
+
+	return result, nil
 }
@@ -0,0 +1,74 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/compute"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// IngressSettings configures the load balancer in front of ingress-nginx
+type IngressSettings struct {
+	Internal bool
+	StaticIP bool
+}
+
+// IngressAddress is the reserved address the ingress-nginx load balancer is pinned to
+type IngressAddress struct {
+	// IP is the GCP regional address handed to the service as its loadBalancerIP
+	IP pulumi.StringOutput
+	// AllocationIds are the AWS Elastic IPs for the NLB, joined with commas, one per public subnet
+	AllocationIds pulumi.StringOutput
+}
+
+// reserveGCPIngressAddress reserves a regional address for the ingress load balancer.
+// An internal address is taken from the cluster's subnetwork.
+func reserveGCPIngressAddress(ctx *pulumi.Context, projectName string, region string, network *NetworkResult, internal bool) (*IngressAddress, error) {
+	addressArgs := &compute.AddressArgs{
+		Region:      pulumi.String(region),
+		AddressType: pulumi.String("EXTERNAL"),
+		Description: pulumi.String("ingress-nginx load balancer"),
+	}
+	if internal {
+		addressArgs.AddressType = pulumi.String("INTERNAL")
+		addressArgs.Subnetwork = network.PrivateSubnetIds[0]
+	}
+
+	address, err := compute.NewAddress(ctx, fmt.Sprintf("%s-ingress-ip", projectName), addressArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Export("ingressIp", address.Address)
+
+	return &IngressAddress{IP: address.Address}, nil
+}
+
+// reserveAWSIngressAddresses allocates an Elastic IP per public subnet, the NLB takes one in each subnet it spans
+func reserveAWSIngressAddresses(ctx *pulumi.Context, projectName string, network *NetworkResult) (*IngressAddress, error) {
+	var allocationIds pulumi.StringArray
+	var publicIps pulumi.StringArray
+	for i := range network.PublicSubnetIds {
+		eip, err := ec2.NewEip(ctx, fmt.Sprintf("ingress-eip-%d", i), &ec2.EipArgs{
+			Domain: pulumi.String("vpc"),
+			Tags: pulumi.StringMap{
+				fmt.Sprintf("kubernetes.io/cluster/%s", projectName): pulumi.String("shared"),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		allocationIds = append(allocationIds, eip.AllocationId)
+		publicIps = append(publicIps, eip.PublicIp)
+	}
+
+	ctx.Export("ingressIps", publicIps)
+
+	return &IngressAddress{
+		AllocationIds: allocationIds.ToStringArrayOutput().ApplyT(func(ids []string) string {
+			return strings.Join(ids, ",")
+		}).(pulumi.StringOutput),
+	}, nil
+}
@@ -0,0 +1,108 @@
+package infrastructure
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const systemUpgradeControllerVersion = "v0.14.2"
+const systemUpgradeNamespace = "system-upgrade"
+
+// InstallK3sUpgradePlans installs the rancher system-upgrade-controller and the plans that move every node to version.
+// Bumping the k3s version in config then updates the plans, and the controller upgrades servers before agents one node at a time.
+func InstallK3sUpgradePlans(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, version string) error {
+	releaseUrl := fmt.Sprintf("https://github.com/rancher/system-upgrade-controller/releases/download/%s", systemUpgradeControllerVersion)
+
+	crds, err := yaml.NewConfigFile(ctx, "system-upgrade-crds", &yaml.ConfigFileArgs{
+		File: releaseUrl + "/crd.yaml",
+	}, pulumi.Provider(kubeProvider))
+	if err != nil {
+		return err
+	}
+
+	controller, err := yaml.NewConfigFile(ctx, "system-upgrade-controller", &yaml.ConfigFileArgs{
+		File: releaseUrl + "/system-upgrade-controller.yaml",
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{crds}))
+	if err != nil {
+		return err
+	}
+
+	controlPlaneSelector := func(operator string) map[string]interface{} {
+		expression := map[string]interface{}{
+			"key":      "node-role.kubernetes.io/control-plane",
+			"operator": operator,
+		}
+		if operator == "In" {
+			expression["values"] = []string{"true"}
+		}
+		return map[string]interface{}{
+			"matchExpressions": []map[string]interface{}{expression},
+		}
+	}
+
+	serverPlan, err := apiextensions.NewCustomResource(ctx, "k3s-server-plan", &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("upgrade.cattle.io/v1"),
+		Kind:       pulumi.String("Plan"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("server-plan"),
+			Namespace: pulumi.String(systemUpgradeNamespace),
+		},
+		OtherFields: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"concurrency":        1,
+				"cordon":             true,
+				"nodeSelector":       controlPlaneSelector("In"),
+				"serviceAccountName": "system-upgrade",
+				"upgrade": map[string]interface{}{
+					"image": "rancher/k3s-upgrade",
+				},
+				"version": version,
+			},
+		},
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{controller}))
+	if err != nil {
+		return err
+	}
+
+	// Agents wait for the server plan to finish before they start
+	_, err = apiextensions.NewCustomResource(ctx, "k3s-agent-plan", &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("upgrade.cattle.io/v1"),
+		Kind:       pulumi.String("Plan"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("agent-plan"),
+			Namespace: pulumi.String(systemUpgradeNamespace),
+		},
+		OtherFields: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"concurrency":        1,
+				"cordon":             true,
+				"nodeSelector":       controlPlaneSelector("DoesNotExist"),
+				"serviceAccountName": "system-upgrade",
+				"prepare": map[string]interface{}{
+					"image": "rancher/k3s-upgrade",
+					"args":  []string{"prepare", "server-plan"},
+				},
+				"drain": map[string]interface{}{
+					"force":                    true,
+					"deleteEmptydirData":       true,
+					"ignoreDaemonSets":         true,
+					"skipWaitForDeleteTimeout": 60,
+				},
+				"upgrade": map[string]interface{}{
+					"image": "rancher/k3s-upgrade",
+				},
+				"version": version,
+			},
+		},
+	}, pulumi.Provider(kubeProvider), pulumi.DependsOn([]pulumi.Resource{serverPlan}))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
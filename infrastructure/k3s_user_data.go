@@ -0,0 +1,173 @@
+package infrastructure
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/dimo/dimo-node/utils"
+)
+
+// k3sBootstrapMarker is written once the user-data script finishes, the k3s install waits for it
+const k3sBootstrapMarker = "/var/lib/dimo/bootstrapped"
+
+// k3sUserData is everything the k3s host user-data script is rendered from
+type k3sUserData struct {
+	User      string
+	PublicKey string
+	// CreateUser adds the ssh user itself, GCP creates it from the ssh-keys instance metadata instead
+	CreateUser bool
+	// EveryBoot has cloud-init run the script on every boot like the GCP startup-script, so user-data changes apply
+	EveryBoot bool
+	Host      utils.K3sHostConfig
+}
+
+// The same script is the GCP startup-script and the AWS user-data, both run on every boot. Debian's GCP
+// images ship without cloud-init, so it is a shell script rather than a #cloud-config, and every step
+// has to be safe to run again.
+var k3sUserDataTemplate = template.Must(template.New("k3s-user-data").Parse(`#!/bin/bash
+set -euo pipefail
+export DEBIAN_FRONTEND=noninteractive
+{{- if .CreateUser }}
+
+# ssh user pulumi installs k3s with
+id -u {{ .User }} >/dev/null 2>&1 || useradd --create-home --shell /bin/bash {{ .User }}
+echo '{{ .User }} ALL=(ALL) NOPASSWD:ALL' > /etc/sudoers.d/90-{{ .User }}
+chmod 440 /etc/sudoers.d/90-{{ .User }}
+install -d -m 700 -o {{ .User }} -g {{ .User }} /home/{{ .User }}/.ssh
+echo '{{ .PublicKey }}' > /home/{{ .User }}/.ssh/authorized_keys
+chown {{ .User }}:{{ .User }} /home/{{ .User }}/.ssh/authorized_keys
+chmod 600 /home/{{ .User }}/.ssh/authorized_keys
+{{- end }}
+
+apt-get update
+{{- if or .Host.Packages .Host.UnattendedUpgrades }}
+apt-get install -y{{ range .Host.Packages }} {{ . }}{{ end }}{{ if .Host.UnattendedUpgrades }} unattended-upgrades{{ end }}
+{{- end }}
+{{- if .Host.UnattendedUpgrades }}
+
+# Security updates only, nodes are never rebooted behind k3s's back
+cat > /etc/apt/apt.conf.d/20auto-upgrades <<'EOF'
+APT::Periodic::Update-Package-Lists "1";
+APT::Periodic::Unattended-Upgrade "1";
+EOF
+cat > /etc/apt/apt.conf.d/52dimo-unattended-upgrades <<'EOF'
+Unattended-Upgrade::Origins-Pattern { "origin=Debian,codename=${distro_codename}-security,label=Debian-Security"; };
+Unattended-Upgrade::Automatic-Reboot "false";
+EOF
+{{- else }}
+
+rm -f /etc/apt/apt.conf.d/20auto-upgrades /etc/apt/apt.conf.d/52dimo-unattended-upgrades
+{{- end }}
+{{- if .Host.Sysctl }}
+
+cat > /etc/sysctl.d/90-dimo.conf <<'EOF'
+{{- range $key, $value := .Host.Sysctl }}
+{{ $key }} = {{ $value }}
+{{- end }}
+EOF
+sysctl --system >/dev/null
+{{- else }}
+
+rm -f /etc/sysctl.d/90-dimo.conf
+{{- end }}
+
+mkdir -p {{ .MarkerDir }}
+touch {{ .Marker }}
+`))
+
+// renderK3sUserData renders the host bootstrap script, it is kept free of pulumi types so it can be checked on its own
+func renderK3sUserData(data k3sUserData) (string, error) {
+	var script bytes.Buffer
+	err := k3sUserDataTemplate.Execute(&script, struct {
+		k3sUserData
+		Marker    string
+		MarkerDir string
+	}{data, k3sBootstrapMarker, path.Dir(k3sBootstrapMarker)})
+	if err != nil {
+		return "", err
+	}
+
+	if data.EveryBoot {
+		return cloudInitEveryBoot(script.String()), nil
+	}
+	return script.String(), nil
+}
+
+// cloudInitEveryBoot wraps a script in the multipart user-data that has cloud-init run scripts on every boot
+// rather than once per instance. AWS restarts the instance when its user-data changes, which re-runs it.
+func cloudInitEveryBoot(script string) string {
+	return `Content-Type: multipart/mixed; boundary="//"
+MIME-Version: 1.0
+
+--//
+Content-Type: text/cloud-config; charset="us-ascii"
+
+#cloud-config
+cloud_final_modules:
+- [scripts-user, always]
+
+--//
+Content-Type: text/x-shellscript; charset="us-ascii"
+
+` + script + `--//--
+`
+}
+
+// k3sInstall is everything a node's get.k3s.io command is built from
+type k3sInstall struct {
+	Role string // server or agent
+	// MultiNode is false for the original single VM layout, which keeps its install command unchanged
+	MultiNode  bool
+	HA         bool
+	Host       utils.K3sHostConfig
+	InternalIp string
+	PublicIp   string
+	JoinIp     string // Internal ip of the first server, empty when installing the first server itself
+	Token      string
+}
+
+// renderK3sInstallCommand builds the get.k3s.io command for a node, free of pulumi types like renderK3sUserData.
+// It waits for the user-data bootstrap to finish so the packages and sysctls are in place before k3s starts.
+func renderK3sInstallCommand(install k3sInstall) string {
+	installer := fmt.Sprintf("timeout 900 sh -c 'until [ -f %s ]; do sleep 5; done' && curl -sfL https://get.k3s.io |", k3sBootstrapMarker)
+	if install.Host.Version != "" {
+		installer += " INSTALL_K3S_VERSION=" + shellQuote(install.Host.Version)
+	}
+
+	extraFlags := install.Host.ServerFlags
+	if install.Role == "agent" {
+		extraFlags = install.Host.AgentFlags
+	}
+	flags := ""
+	for _, flag := range extraFlags {
+		flags += " " + shellQuote(flag)
+	}
+
+	serverArgs := fmt.Sprintf("--bind-address %s --tls-san %s --advertise-address %s --advertise-address %s --disable servicelb --write-kubeconfig-mode=644%s",
+		install.InternalIp, install.PublicIp, install.InternalIp, install.InternalIp, flags)
+
+	if !install.MultiNode {
+		return fmt.Sprintf("%s sh -s -- %s", installer, serverArgs)
+	}
+
+	switch {
+	case install.Role == "agent":
+		return fmt.Sprintf("%s K3S_TOKEN=%s sh -s - agent --server https://%s:6443 --node-ip %s%s",
+			installer, install.Token, install.JoinIp, install.InternalIp, flags)
+	case install.JoinIp != "":
+		return fmt.Sprintf("%s K3S_TOKEN=%s sh -s - server --server https://%s:6443 %s",
+			installer, install.Token, install.JoinIp, serverArgs)
+	case install.HA:
+		return fmt.Sprintf("%s K3S_TOKEN=%s sh -s - server --cluster-init %s", installer, install.Token, serverArgs)
+	default:
+		return fmt.Sprintf("%s K3S_TOKEN=%s sh -s - server %s", installer, install.Token, serverArgs)
+	}
+}
+
+// shellQuote wraps a value in single quotes so it reaches the command untouched
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,169 @@
+package infrastructure
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dimo/dimo-node/utils"
+)
+
+func TestRenderK3sUserData(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     k3sUserData
+		contains []string
+		excludes []string
+	}{
+		{
+			name: "aws creates the ssh user",
+			data: k3sUserData{User: "ubuntu", PublicKey: "ssh-ed25519 AAAA", CreateUser: true, Host: utils.K3sHostConfig{Packages: []string{"jq"}}},
+			contains: []string{
+				"useradd --create-home --shell /bin/bash ubuntu",
+				"echo 'ssh-ed25519 AAAA' > /home/ubuntu/.ssh/authorized_keys",
+				"apt-get install -y jq\n",
+			},
+		},
+		{
+			name:     "gcp leaves the ssh user to the metadata",
+			data:     k3sUserData{User: "ubuntu", PublicKey: "ssh-ed25519 AAAA", Host: utils.K3sHostConfig{Packages: []string{"jq"}}},
+			excludes: []string{"useradd", "authorized_keys"},
+		},
+		{
+			name: "unattended upgrades and sysctls",
+			data: k3sUserData{User: "ubuntu", Host: utils.K3sHostConfig{
+				Packages:           []string{"jq", "htop"},
+				UnattendedUpgrades: true,
+				Sysctl:             map[string]string{"vm.max_map_count": "262144", "fs.inotify.max_user_instances": "512"},
+			}},
+			contains: []string{
+				"apt-get install -y jq htop unattended-upgrades\n",
+				"Unattended-Upgrade::Automatic-Reboot \"false\";",
+				"fs.inotify.max_user_instances = 512\nvm.max_map_count = 262144\n",
+				"sysctl --system",
+			},
+			excludes: []string{"rm -f /etc/apt/apt.conf.d/20auto-upgrades", "rm -f /etc/sysctl.d/90-dimo.conf"},
+		},
+		{
+			name:     "no packages removes what an earlier boot wrote",
+			data:     k3sUserData{User: "ubuntu", Host: utils.K3sHostConfig{Packages: []string{}}},
+			contains: []string{"rm -f /etc/apt/apt.conf.d/20auto-upgrades", "rm -f /etc/sysctl.d/90-dimo.conf"},
+			excludes: []string{"apt-get install", "sysctl --system"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := renderK3sUserData(test.data)
+			if err != nil {
+				t.Fatalf("renderK3sUserData: %v", err)
+			}
+
+			if !strings.HasPrefix(script, "#!/bin/bash\n") {
+				t.Errorf("script does not start with a shebang:\n%s", script)
+			}
+			// The install waits for the marker, so it has to be the last thing the script does
+			if !strings.HasSuffix(script, "mkdir -p /var/lib/dimo\ntouch "+k3sBootstrapMarker+"\n") {
+				t.Errorf("script does not end by writing %s:\n%s", k3sBootstrapMarker, script)
+			}
+			for _, want := range test.contains {
+				if !strings.Contains(script, want) {
+					t.Errorf("script is missing %q:\n%s", want, script)
+				}
+			}
+			for _, unwanted := range test.excludes {
+				if strings.Contains(script, unwanted) {
+					t.Errorf("script contains %q:\n%s", unwanted, script)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderK3sUserDataEveryBoot(t *testing.T) {
+	data := k3sUserData{User: "pulumi", PublicKey: "ssh-ed25519 BBBB", CreateUser: true, Host: utils.K3sHostConfig{Packages: []string{"jq"}}}
+	script, err := renderK3sUserData(data)
+	if err != nil {
+		t.Fatalf("renderK3sUserData: %v", err)
+	}
+
+	data.EveryBoot = true
+	userData, err := renderK3sUserData(data)
+	if err != nil {
+		t.Fatalf("renderK3sUserData: %v", err)
+	}
+
+	for _, want := range []string{
+		"Content-Type: multipart/mixed; boundary=\"//\"\n",
+		"#cloud-config\ncloud_final_modules:\n- [scripts-user, always]\n",
+		"Content-Type: text/x-shellscript; charset=\"us-ascii\"\n\n" + script + "--//--\n",
+	} {
+		if !strings.Contains(userData, want) {
+			t.Errorf("user-data is missing %q:\n%s", want, userData)
+		}
+	}
+}
+
+func TestRenderK3sInstallCommand(t *testing.T) {
+	const wait = "timeout 900 sh -c 'until [ -f /var/lib/dimo/bootstrapped ]; do sleep 5; done' && curl -sfL https://get.k3s.io |"
+	const serverArgs = "--bind-address 10.0.0.2 --tls-san 34.1.2.3 --advertise-address 10.0.0.2 --advertise-address 10.0.0.2 --disable servicelb --write-kubeconfig-mode=644"
+	host := utils.K3sHostConfig{ServerFlags: []string{"--disable=traefik"}, AgentFlags: []string{"--node-label=pool=agents"}}
+
+	tests := []struct {
+		name    string
+		install k3sInstall
+		want    string
+	}{
+		{
+			name:    "single node server",
+			install: k3sInstall{Role: "server", InternalIp: "10.0.0.2", PublicIp: "34.1.2.3"},
+			want:    wait + " sh -s -- " + serverArgs,
+		},
+		{
+			name:    "single node pinned version and flags",
+			install: k3sInstall{Role: "server", Host: utils.K3sHostConfig{Version: "v1.30.4+k3s1", ServerFlags: []string{"--disable=traefik"}}, InternalIp: "10.0.0.2", PublicIp: "34.1.2.3"},
+			want:    wait + " INSTALL_K3S_VERSION='v1.30.4+k3s1' sh -s -- " + serverArgs + " '--disable=traefik'",
+		},
+		{
+			name:    "non-HA first server",
+			install: k3sInstall{Role: "server", MultiNode: true, Host: host, InternalIp: "10.0.0.2", PublicIp: "34.1.2.3", Token: "secret"},
+			want:    wait + " K3S_TOKEN=secret sh -s - server " + serverArgs + " '--disable=traefik'",
+		},
+		{
+			name:    "HA first server initialises etcd",
+			install: k3sInstall{Role: "server", MultiNode: true, HA: true, Host: host, InternalIp: "10.0.0.2", PublicIp: "34.1.2.3", Token: "secret"},
+			want:    wait + " K3S_TOKEN=secret sh -s - server --cluster-init " + serverArgs + " '--disable=traefik'",
+		},
+		{
+			name:    "HA server joins the first server",
+			install: k3sInstall{Role: "server", MultiNode: true, HA: true, Host: host, InternalIp: "10.0.0.2", PublicIp: "34.1.2.3", JoinIp: "10.0.0.1", Token: "secret"},
+			want:    wait + " K3S_TOKEN=secret sh -s - server --server https://10.0.0.1:6443 " + serverArgs + " '--disable=traefik'",
+		},
+		{
+			name:    "agent joins with agent flags",
+			install: k3sInstall{Role: "agent", MultiNode: true, Host: host, InternalIp: "10.0.0.3", PublicIp: "34.1.2.4", JoinIp: "10.0.0.1", Token: "secret"},
+			want:    wait + " K3S_TOKEN=secret sh -s - agent --server https://10.0.0.1:6443 --node-ip 10.0.0.3 '--node-label=pool=agents'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := renderK3sInstallCommand(test.install)
+			if got != test.want {
+				t.Errorf("renderK3sInstallCommand()\n got: %s\nwant: %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"--disable=traefik": `'--disable=traefik'`,
+		"it's":              `'it'\''s'`,
+		"":                  `''`,
+	}
+	for value, want := range tests {
+		if got := shellQuote(value); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
@@ -1,157 +1,175 @@
 package infrastructure
 
-/*
 import (
-	"context"
+	"encoding/base64"
 	"fmt"
 
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/containerservice/mgmt/containerservice"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
-	"github.com/Azure/go-autorest/autorest/to"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-azure-native-sdk/authorization/v2"
+	"github.com/pulumi/pulumi-azure-native-sdk/containerservice/v2"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
+func init() {
+	RegisterClusterProvider("aks", newAKSClusterProvider)
+}
+
+// AKSSettings sizes the AKS node pools
+type AKSSettings struct {
+	SystemPool utils.AKSNodePool
+	UserPool   utils.AKSNodePool
+}
+
+// aksClusterProvider builds an AKS managed cluster on an Azure VNet
+type aksClusterProvider struct {
+	settings      ClusterSettings
+	network       *NetworkResult
+	cluster       *containerservice.ManagedCluster
+	networkAccess *authorization.RoleAssignment
+}
 
+func newAKSClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &aksClusterProvider{settings: settings}
+}
+
+func (p *aksClusterProvider) CreateNetwork(ctx *pulumi.Context) (err error) {
+	p.network, err = CreateNetwork(ctx, p.settings.CloudProvider, p.settings.Region, p.settings.ProjectName, p.settings.FirewallRules)
+	return err
+}
 
-func createAKSKubernetesCluster(ctx context.Context, plumiContext *schema.ResourceData) (*containerservice.ManagedCluster, error) {
-	// Get AKS credentials
-	aksCredentials, err := auth.NewAuthorizerFromEnvironment()
+func (p *aksClusterProvider) CreateCluster(ctx *pulumi.Context) (err error) {
+	p.cluster, err = CreateAKSCluster(ctx, p.settings.ProjectName, p.network, p.settings.AKS.SystemPool)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get AKS credentials: %v", err)
+		return err
 	}
 
-	// Create AKS client
-	aksClient := containerservice.NewManagedClustersClient(plumiContext.Get("subscription_id").(string))
-	aksClient.Authorizer = aksCredentials
-
-	// Define cluster properties
-	clusterName := plumiContext.Get("cluster_name").(string)
-	resourceGroupName := plumiContext.Get("resource_group_name").(string)
-	location := plumiContext.Get("location").(string)
-
-	// Define node pool properties
-	mediumNodePoolName := "medium"
-	mediumNodePoolVMSize := "Standard_D4_v3"
-	mediumNodePoolCount := int32(1)
-	mediumNodePoolOSDiskSizeGB := int32(30)
-	mediumNodePoolVNetSubnetID := plumiContext.Get("vnet_subnet_id").(string)
-
-	smallNodePoolName := "small"
-	smallNodePoolVMSize := "Standard_D2_v3"
-	smallNodePoolCount := int32(1)
-	smallNodePoolOSDiskSizeGB := int32(30)
-	smallNodePoolVNetSubnetID := plumiContext.Get("vnet_subnet_id").(string)
-
-	// Define cluster properties
-	clusterProperties := &containerservice.ManagedClusterProperties{
-		DNSPrefix:         &clusterName,
-		NodeResourceGroup: &resourceGroupName,
-		KubernetesVersion: to.StringPtr("1.19.11"),
-		AgentPoolProfiles: &[]containerservice.ManagedClusterAgentPoolProfile{
-			{
-				Name:         &mediumNodePoolName,
-				Count:        &mediumNodePoolCount,
-				VMSize:       &mediumNodePoolVMSize,
-				OsDiskSizeGB: &mediumNodePoolOSDiskSizeGB,
-				VnetSubnetID: &mediumNodePoolVNetSubnetID,
-				MaxPods:      to.Int32Ptr(30),
-				Type:         containerservice.VirtualMachineScaleSets,
-				Mode:         containerservice.System,
-				StorageProfile: &containerservice.ManagedClusterAgentPoolProfileStorageProfile{
-					OsDisk: &containerservice.ManagedClusterAgentPoolProfileStorageProfileOsDisk{
-						DiskSizeGB: &mediumNodePoolOSDiskSizeGB,
-						OsType:     containerservice.Linux,
-						ManagedDisk: &containerservice.ManagedClusterAgentPoolProfileStorageProfileManagedDisk{
-							StorageAccountType: containerservice.StorageAccountTypePremiumLRS,
-						},
-					},
-				},
-			},
-			{
-				Name:         &smallNodePoolName,
-				Count:        &smallNodePoolCount,
-				VMSize:       &smallNodePoolVMSize,
-				OsDiskSizeGB: &smallNodePoolOSDiskSizeGB,
-				VnetSubnetID: &smallNodePoolVNetSubnetID,
-				MaxPods:      to.Int32Ptr(30),
-				Type:         containerservice.VirtualMachineScaleSets,
-				Mode:         containerservice.System,
-				StorageProfile: &containerservice.ManagedClusterAgentPoolProfileStorageProfile{
-					OsDisk: &containerservice.ManagedClusterAgentPoolProfileStorageProfileOsDisk{
-						DiskSizeGB: &smallNodePoolOSDiskSizeGB,
-						OsType:     containerservice.Linux,
-						ManagedDisk: &containerservice.ManagedClusterAgentPoolProfileStorageProfileManagedDisk{
-							StorageAccountType: containerservice.StorageAccountTypePremiumLRS,
-						},
-					},
-				},
+	p.networkAccess, err = grantAKSNetworkAccess(ctx, p.settings.ProjectName, p.cluster, p.network)
+	return err
+}
+
+func (p *aksClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return CreateAKSNodePools(ctx, p.settings.ProjectName, p.cluster, p.network, p.settings.AKS.UserPool)
+}
+
+func (p *aksClusterProvider) GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	return NewAKSKubernetesProvider(ctx, p.cluster, p.network, p.networkAccess)
+}
+
+func CreateAKSCluster(ctx *pulumi.Context, projectName string, network *NetworkResult, systemPool utils.AKSNodePool) (*containerservice.ManagedCluster, error) {
+	// The system pool runs the AKS system pods, workloads go on the user pool from CreateAKSNodePools.
+	// Pool changes after the cluster exists are ignored below, so the system pool is only sized on create.
+	cluster, err := containerservice.NewManagedCluster(ctx, projectName, &containerservice.ManagedClusterArgs{
+		ResourceGroupName: network.ResourceGroupName,
+		DnsPrefix:         pulumi.String(projectName),
+		EnableRBAC:        pulumi.Bool(true),
+		Identity: &containerservice.ManagedClusterIdentityArgs{
+			Type: containerservice.ResourceIdentityTypeSystemAssigned,
+		},
+		AgentPoolProfiles: containerservice.ManagedClusterAgentPoolProfileArray{
+			&containerservice.ManagedClusterAgentPoolProfileArgs{
+				Name:              pulumi.String("system"),
+				Mode:              pulumi.String("System"),
+				Count:             pulumi.Int(systemPool.MinNodes),
+				EnableAutoScaling: pulumi.Bool(systemPool.Autoscaling()),
+				MinCount:          aksPoolBound(systemPool, systemPool.MinNodes),
+				MaxCount:          aksPoolBound(systemPool, systemPool.MaxNodes),
+				VmSize:            pulumi.String(systemPool.VmSize),
+				OsDiskSizeGB:      pulumi.Int(systemPool.DiskSizeGb),
+				OsType:            pulumi.String("Linux"),
+				Type:              pulumi.String("VirtualMachineScaleSets"),
+				VnetSubnetID:      network.PrivateSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
 			},
 		},
+		NetworkProfile: &containerservice.ContainerServiceNetworkProfileArgs{
+			NetworkPlugin: pulumi.String("kubenet"),
+			ServiceCidr:   pulumi.String("10.1.0.0/16"),
+			DnsServiceIP:  pulumi.String("10.1.0.10"),
+		},
+	}, pulumi.IgnoreChanges([]string{"agentPoolProfiles"})) // Pools from CreateAKSNodePools show up here too
+	if err != nil {
+		return nil, err
 	}
 
-	// Create cluster
-	cluster, err := aksClient.CreateOrUpdate(ctx, resourceGroupName, clusterName, containerservice.ManagedCluster{
-		Location:                 &location,
-		ManagedClusterProperties: clusterProperties,
-	})
+	ctx.Export("clusterName", cluster.Name)
+	ctx.Export("clusterEndpoint", cluster.Fqdn)
+
+	return cluster, nil
+}
+
+// Network Contributor, built in to every subscription
+const azureNetworkContributorRole = "4d97b98b-1d4f-4787-a291-c67834d212e7"
+
+// grantAKSNetworkAccess lets the cluster's identity manage our subnet. With kubenet on a subnet AKS didn't create,
+// it has to attach its route table to the subnet and put load balancers in it, which fails without this.
+func grantAKSNetworkAccess(ctx *pulumi.Context, projectName string, cluster *containerservice.ManagedCluster, network *NetworkResult) (*authorization.RoleAssignment, error) {
+	clientConfig, err := authorization.GetClientConfig(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AKS cluster: %v", err)
+		return nil, err
 	}
 
-	return &cluster, nil
+	return authorization.NewRoleAssignment(ctx, projectName+"-network-contributor", &authorization.RoleAssignmentArgs{
+		PrincipalId:      cluster.Identity.PrincipalId().Elem(),
+		PrincipalType:    pulumi.String("ServicePrincipal"),
+		RoleDefinitionId: pulumi.String(fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Authorization/roleDefinitions/%s", clientConfig.SubscriptionId, azureNetworkContributorRole)),
+		Scope:            network.PrivateSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
+	})
 }
 
-func createKubernetesProvider(ctx context.Context, plumiContext *schema.ResourceData, cluster *containerservice.ManagedCluster) (*kubernetes.Provider, error) {
-	// Get AKS credentials
-	aksCredentials, err := auth.NewAuthorizerFromEnvironment()
+func CreateAKSNodePools(ctx *pulumi.Context, projectName string, cluster *containerservice.ManagedCluster, network *NetworkResult, userPool utils.AKSNodePool) (err error) {
+	// Create the user node pool
+	_, err = containerservice.NewAgentPool(ctx, projectName+"-user", &containerservice.AgentPoolArgs{
+		AgentPoolName:     pulumi.String("user"),
+		ResourceGroupName: network.ResourceGroupName,
+		ResourceName:      cluster.Name,
+		Mode:              pulumi.String("User"),
+		Count:             pulumi.Int(userPool.MinNodes),
+		EnableAutoScaling: pulumi.Bool(userPool.Autoscaling()),
+		MinCount:          aksPoolBound(userPool, userPool.MinNodes),
+		MaxCount:          aksPoolBound(userPool, userPool.MaxNodes),
+		VmSize:            pulumi.String(userPool.VmSize),
+		OsDiskSizeGB:      pulumi.Int(userPool.DiskSizeGb),
+		OsType:            pulumi.String("Linux"),
+		Type:              pulumi.String("VirtualMachineScaleSets"),
+		VnetSubnetID:      network.PrivateSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get AKS credentials: %v", err)
+		return err
 	}
 
-	// Define provider properties
-	providerProperties := map[string]interface{}{
-		"host": fmt.Sprintf("https://%s", *cluster.Fqdn),
-		"client_certificate": []map[string]string{
-			{
-				"client_certificate":     *cluster.ManagedClusterProperties.ManagedClusterAPIServerAccessProfile.KubeConfig[0].ClientCertificateData,
-				"client_key":             *cluster.ManagedClusterProperties.ManagedClusterAPIServerAccessProfile.KubeConfig[0].ClientKeyData,
-				"cluster_ca_certificate": *cluster.ManagedClusterProperties.ManagedClusterAPIServerAccessProfile.KubeConfig[0].ClusterCACertificateData,
-			},
-		},
-		"load_config_file": false,
+	return nil
+}
+
+// aksPoolBound returns an autoscaling bound, AKS rejects min and max counts on a pool that doesn't autoscale
+func aksPoolBound(pool utils.AKSNodePool, count int) pulumi.IntPtrInput {
+	if !pool.Autoscaling() {
+		return nil
 	}
+	return pulumi.IntPtr(count)
+}
 
-	// Create provider
-	provider, err := kubernetes.NewProvider(kubernetes.ProviderConfig{
-		Host: *cluster.Fqdn,
-		ClientConfig: &clientcmdapi.Config{
-			Clusters: map[string]*clientcmdapi.Cluster{
-				*cluster.Name: {
-					Server:                   fmt.Sprintf("https://%s", *cluster.Fqdn),
-					CertificateAuthorityData: []byte(*cluster.ManagedClusterProperties.ManagedClusterAPIServerAccessProfile.KubeConfig[0].ClusterCACertificateData),
-				},
-			},
-			AuthInfos: map[string]*clientcmdapi.AuthInfo{
-				*cluster.Name: {
-					ClientCertificateData: []byte(*cluster.ManagedClusterProperties.ManagedClusterAPIServerAccessProfile.KubeConfig[0].ClientCertificateData),
-					ClientKeyData:         []byte(*cluster.ManagedClusterProperties.ManagedClusterAPIServerAccessProfile.KubeConfig[0].ClientKeyData),
-				},
-			},
-			Contexts: map[string]*clientcmdapi.Context{
-				*cluster.Name: {
-					Cluster:  *cluster.Name,
-					AuthInfo: *cluster.Name,
-				},
-			},
-			CurrentContext: *cluster.Name,
-		},
-		LoadConfigFile: false,
-	}, providerProperties)
+// NewAKSKubernetesProvider waits for networkAccess, so nothing that needs a load balancer is created before AKS can make one
+func NewAKSKubernetesProvider(ctx *pulumi.Context, cluster *containerservice.ManagedCluster, network *NetworkResult, networkAccess *authorization.RoleAssignment) (*kubernetes.Provider, error) {
+	// AKS hands back the user kubeconfig base64 encoded
+	credentials := containerservice.ListManagedClusterUserCredentialsOutput(ctx, containerservice.ListManagedClusterUserCredentialsOutputArgs{
+		ResourceGroupName: network.ResourceGroupName,
+		ResourceName:      cluster.Name,
+	})
+
+	kubeConfig := credentials.Kubeconfigs().Index(pulumi.Int(0)).Value().ApplyT(func(encoded string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", err
+		}
+		return string(decoded), nil
+	}).(pulumi.StringOutput)
+
+	kubeProvider, err := kubernetes.NewProvider(ctx, "AKSk8sProvider", &kubernetes.ProviderArgs{
+		Kubeconfig: pulumi.ToSecret(kubeConfig).(pulumi.StringOutput),
+	}, pulumi.DependsOn([]pulumi.Resource{networkAccess}))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Kubernetes provider: %v", err)
+		return nil, err
 	}
 
-	return provider, nil
+	return kubeProvider, nil
 }
-
-*/
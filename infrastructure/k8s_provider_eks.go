@@ -1,27 +1,89 @@
 package infrastructure
 
 import (
+	"github.com/dimo/dimo-node/utils"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
-	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
-	schedulingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/scheduling/v1"
+	"github.com/pulumi/pulumi-tls/sdk/v4/go/tls"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
 // Example can be found here: https://github.com/scottslowe/learning-tools/blob/main/pulumi/eks-from-scratch/main.go
 
-func CreateEKSKubernetesCluster(ctx *pulumi.Context, projectName string, location string) (*eks.Cluster, error) {
-	err := buildAWSNetworking(ctx)
+func init() {
+	RegisterClusterProvider("eks", newEKSClusterProvider)
+}
+
+// EKSSettings configures the EKS cluster version and its node groups
+type EKSSettings struct {
+	Version          string
+	DefaultNodeGroup utils.EKSNodeGroup
+	NodeGroups       []utils.EKSNodeGroup
+	UpgradeSettings  *utils.UpgradeSettings // One node at a time when unset
+}
+
+// eksClusterProvider builds an EKS cluster in its own AWS VPC
+type eksClusterProvider struct {
+	settings       ClusterSettings
+	network        *NetworkResult
+	cluster        *eks.Cluster
+	ingressAddress *IngressAddress
+	oidcProvider   *iam.OpenIdConnectProvider
+}
+
+func newEKSClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &eksClusterProvider{settings: settings}
+}
+
+func (p *eksClusterProvider) CreateNetwork(ctx *pulumi.Context) (err error) {
+	p.network, err = CreateNetwork(ctx, p.settings.CloudProvider, p.settings.Region, p.settings.ProjectName, p.settings.FirewallRules)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	createIam(ctx)
+	if p.settings.Ingress.StaticIP {
+		p.ingressAddress, err = reserveAWSIngressAddresses(ctx, p.settings.ProjectName, p.network)
+	}
+	return err
+}
+
+func (p *eksClusterProvider) IngressAddress() *IngressAddress {
+	return p.ingressAddress
+}
+
+func (p *eksClusterProvider) CreateCluster(ctx *pulumi.Context) (err error) {
+	p.cluster, err = CreateEKSKubernetesCluster(ctx, p.settings.ProjectName, p.settings.Region, p.network, p.settings.EKS)
+	if err != nil {
+		return err
+	}
+
+	p.oidcProvider, err = createEKSOidcProvider(ctx, p.cluster)
+	return err
+}
+
+func (p *eksClusterProvider) OidcProvider() *iam.OpenIdConnectProvider {
+	return p.oidcProvider
+}
+
+func (p *eksClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return CreateEKSKubernetesNodePools(ctx, p.settings.ProjectName, p.cluster, p.network, p.settings.EKS)
+}
+
+func (p *eksClusterProvider) GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	return NewEKSKubernetesProvider(ctx, p.cluster)
+}
+
+func CreateEKSKubernetesCluster(ctx *pulumi.Context, projectName string, location string, network *NetworkResult, settings EKSSettings) (*eks.Cluster, error) {
+	err := createIam(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a Security Group that we can use to actually connect to our cluster
 	clusterSg, err := ec2.NewSecurityGroup(ctx, "cluster-sg", &ec2.SecurityGroupArgs{
-		VpcId: vpcId,
+		VpcId: network.NetworkId,
 		Egress: ec2.SecurityGroupEgressArray{
 			ec2.SecurityGroupEgressArgs{
 				Protocol:   pulumi.String("-1"),
@@ -50,14 +112,14 @@ func CreateEKSKubernetesCluster(ctx *pulumi.Context, projectName string, locatio
 	}
 
 	// Create the EKS cluster
-	cluster, err := eks.NewCluster(ctx, projectName, &eks.ClusterArgs{
+	clusterArgs := &eks.ClusterArgs{
 		Name:    pulumi.String(projectName),
 		RoleArn: eksClusterRoleArn,
 		VpcConfig: &eks.ClusterVpcConfigArgs{
 			EndpointPrivateAccess: pulumi.Bool(false),
 			EndpointPublicAccess:  pulumi.Bool(true),
-			SubnetIds:             privateSubnets,
-			SecurityGroupIds:      pulumi.StringArray{clusterSg.ID()},
+			SubnetIds:             network.PrivateSubnetIds,
+			SecurityGroupIds:      append(pulumi.StringArray{clusterSg.ID()}, network.FirewallIds...),
 		},
 		/*
 			Tags: pulumi.StringMap{
@@ -70,25 +132,20 @@ func CreateEKSKubernetesCluster(ctx *pulumi.Context, projectName string, locatio
 						MinSize:     pulumi.Int(1),
 						MaxSize:     pulumi.Int(5),
 					}, */
-	})
+	}
+
+	// Pin the control plane so the stack is reproducible, AWS picks the latest version when unset
+	if settings.Version != "" {
+		clusterArgs.Version = pulumi.String(settings.Version)
+	}
+
+	cluster, err := eks.NewCluster(ctx, projectName, clusterArgs)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create a node group for the EKS cluster
-	_, err = eks.NewNodeGroup(ctx, "node-group", &eks.NodeGroupArgs{
-		ClusterName: cluster.Name,
-		NodeRoleArn: eksNodeRoleArn,
-		SubnetIds:   privateSubnets,
-		ScalingConfig: &eks.NodeGroupScalingConfigArgs{
-			DesiredSize: pulumi.Int(3),
-			MaxSize:     pulumi.Int(6),
-			MinSize:     pulumi.Int(3),
-		},
-		UpdateConfig: &eks.NodeGroupUpdateConfigArgs{
-			MaxUnavailable: pulumi.Int(1),
-		},
-	})
+	_, err = eks.NewNodeGroup(ctx, settings.DefaultNodeGroup.Name, eksNodeGroupArgs(cluster, network, settings, settings.DefaultNodeGroup))
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +161,23 @@ func CreateEKSKubernetesCluster(ctx *pulumi.Context, projectName string, locatio
 	return cluster, nil
 }
 
-func CreateEKSKubernetesNodePools(ctx *pulumi.Context, projectName string, cluster *eks.Cluster, location string) (err error) {
+// createEKSOidcProvider registers the cluster's service account token issuer with IAM
+func createEKSOidcProvider(ctx *pulumi.Context, cluster *eks.Cluster) (*iam.OpenIdConnectProvider, error) {
+	issuer := cluster.Identities.Index(pulumi.Int(0)).Oidcs().Index(pulumi.Int(0)).Issuer()
+	certificate := tls.GetCertificateOutput(ctx, tls.GetCertificateOutputArgs{
+		Url: issuer,
+	})
+
+	return iam.NewOpenIdConnectProvider(ctx, "eks-oidc-provider", &iam.OpenIdConnectProviderArgs{
+		Url:           issuer.Elem(),
+		ClientIdLists: pulumi.StringArray{pulumi.String("sts.amazonaws.com")},
+		ThumbprintLists: pulumi.StringArray{
+			certificate.Certificates().Index(pulumi.Int(0)).Sha1Fingerprint(),
+		},
+	})
+}
+
+func CreateEKSKubernetesNodePools(ctx *pulumi.Context, projectName string, cluster *eks.Cluster, network *NetworkResult, settings EKSSettings) (err error) {
 	/*
 		managedPolicyArns := []string{
 			"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
@@ -158,70 +231,71 @@ func CreateEKSKubernetesNodePools(ctx *pulumi.Context, projectName string, clust
 			}
 	*/
 
-	// Create the medium node pool
-	// Create an EKS node pool
+	// Create the configured node groups
+	for _, group := range settings.NodeGroups {
+		_, err = eks.NewNodeGroup(ctx, projectName+"-"+group.Name, eksNodeGroupArgs(cluster, network, settings, group))
+		if err != nil {
+			return err
+		}
+	}
 
-	_, err = eks.NewNodeGroup(ctx, projectName+"-small", &eks.NodeGroupArgs{
-		ClusterName: cluster.Name,
-		//InstanceTypes: pulumi.StringArray{pulumi.String("t2.small")},
-		NodeRoleArn: eksNodeRoleArn,
-		//SubnetIds:   privateSubnets,
-		ScalingConfig: &eks.NodeGroupScalingConfigArgs{
-			DesiredSize: pulumi.Int(3),
-			MinSize:     pulumi.Int(1),
-			MaxSize:     pulumi.Int(5),
-		},
-		UpdateConfig: &eks.NodeGroupUpdateConfigArgs{
-			MaxUnavailable: pulumi.Int(1),
-		},
-		//InstanceProfile: instanceProfile1.Name,
-		/*
-			Labels: map[string]string{
-				"ondemand": "true",
-			},
-		*/
-	})
-	if err != nil {
-		return err
+	return nil
+}
+
+// eksNodeGroupArgs builds a node group from its config, optional fields are left unset when not configured
+// since most of them replace the node group when they change
+func eksNodeGroupArgs(cluster *eks.Cluster, network *NetworkResult, settings EKSSettings, group utils.EKSNodeGroup) *eks.NodeGroupArgs {
+	maxUnavailable := 1
+	if settings.UpgradeSettings != nil {
+		maxUnavailable = settings.UpgradeSettings.MaxUnavailable
 	}
 
-	_, err = eks.NewNodeGroup(ctx, projectName+"-medium", &eks.NodeGroupArgs{
+	nodeGroupArgs := &eks.NodeGroupArgs{
 		ClusterName: cluster.Name,
-		//InstanceTypes: pulumi.StringArray{pulumi.String("t2.medium")},
 		NodeRoleArn: eksNodeRoleArn,
-		//SubnetIds:   privateSubnets,
+		SubnetIds:   network.PrivateSubnetIds,
 		ScalingConfig: &eks.NodeGroupScalingConfigArgs{
-			DesiredSize: pulumi.Int(3),
-			MinSize:     pulumi.Int(1),
-			MaxSize:     pulumi.Int(5),
+			DesiredSize: pulumi.Int(group.DesiredSize),
+			MinSize:     pulumi.Int(group.MinSize),
+			MaxSize:     pulumi.Int(group.MaxSize),
 		},
 		UpdateConfig: &eks.NodeGroupUpdateConfigArgs{
-			MaxUnavailable: pulumi.Int(1),
+			MaxUnavailable: pulumi.Int(maxUnavailable),
 		},
-		/*
-			Labels: map[string]string{
-				"preemptible": "true",
-			},
-		*/
-	})
-	if err != nil {
-		return err
 	}
 
-	_, err = schedulingv1.NewPriorityClass(ctx, "high-priority", &schedulingv1.PriorityClassArgs{
-		Value: pulumi.Int(100000), // High priority value
-		Metadata: &metav1.ObjectMetaArgs{
-			Name: pulumi.String("high-priority"),
-		},
-		Description:      pulumi.String("This is a high priority class"),
-		GlobalDefault:    pulumi.Bool(false),
-		PreemptionPolicy: pulumi.String("PreemptLowerPriority"),
-	})
-	if err != nil {
-		return err
+	// Nodes follow the pinned control plane version
+	if settings.Version != "" {
+		nodeGroupArgs.Version = pulumi.String(settings.Version)
+	}
+	if len(group.InstanceTypes) > 0 {
+		nodeGroupArgs.InstanceTypes = pulumi.ToStringArray(group.InstanceTypes)
+	}
+	if group.CapacityType != "" {
+		nodeGroupArgs.CapacityType = pulumi.String(group.CapacityType)
+	}
+	if group.AmiType != "" {
+		nodeGroupArgs.AmiType = pulumi.String(group.AmiType)
+	}
+	if group.DiskSizeGb != 0 {
+		nodeGroupArgs.DiskSize = pulumi.Int(group.DiskSizeGb)
+	}
+	if len(group.Labels) > 0 {
+		nodeGroupArgs.Labels = pulumi.ToStringMap(group.Labels)
+	}
+	taints := eks.NodeGroupTaintArray{}
+	for _, taint := range group.Taints {
+		taints = append(taints, &eks.NodeGroupTaintArgs{
+			Key:    pulumi.String(taint.Key),
+			Value:  pulumi.String(taint.Value),
+			Effect: pulumi.String(taint.APIEffect()),
+		})
+	}
+	if len(taints) > 0 {
+		nodeGroupArgs.Taints = taints
 	}
 
-	return nil
+	return nodeGroupArgs
 }
 
 func NewEKSKubernetesProvider(ctx *pulumi.Context, cluster *eks.Cluster) (*kubernetes.Provider, error) {
@@ -0,0 +1,65 @@
+package infrastructure
+
+import (
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	RegisterClusterProvider("existing", newExistingClusterProvider)
+}
+
+// ExistingClusterSettings points at a cluster the operator already runs.
+// Kubeconfig wins over KubeconfigPath, and with neither set the ambient kubeconfig (KUBECONFIG or ~/.kube/config) is used.
+type ExistingClusterSettings struct {
+	Kubeconfig     *pulumi.StringOutput // Secret kubeconfig contents
+	KubeconfigPath string
+	Context        string
+}
+
+// existingClusterProvider skips network and cluster creation and only connects to a cluster that is already running
+type existingClusterProvider struct {
+	settings ClusterSettings
+}
+
+func newExistingClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &existingClusterProvider{settings: settings}
+}
+
+func (p *existingClusterProvider) CreateNetwork(ctx *pulumi.Context) error {
+	return nil
+}
+
+func (p *existingClusterProvider) CreateCluster(ctx *pulumi.Context) error {
+	return nil
+}
+
+func (p *existingClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return nil
+}
+
+func (p *existingClusterProvider) GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	return NewExistingKubernetesProvider(ctx, p.settings.Existing)
+}
+
+func NewExistingKubernetesProvider(ctx *pulumi.Context, settings ExistingClusterSettings) (*kubernetes.Provider, error) {
+	providerArgs := &kubernetes.ProviderArgs{}
+
+	// The provider accepts either kubeconfig contents or a path to a kubeconfig file
+	if settings.Kubeconfig != nil {
+		providerArgs.Kubeconfig = *settings.Kubeconfig
+	} else if settings.KubeconfigPath != "" {
+		providerArgs.Kubeconfig = pulumi.String(settings.KubeconfigPath)
+	}
+
+	if settings.Context != "" {
+		providerArgs.Context = pulumi.String(settings.Context)
+	}
+
+	kubeProvider, err := kubernetes.NewProvider(ctx, "Existingk8sProvider", providerArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return kubeProvider, nil
+}
@@ -1,13 +1,13 @@
 package infrastructure
 
 import (
+	"encoding/base64"
 	"fmt"
 
+	"github.com/dimo/dimo-node/utils"
 	//"github.com/pulumi/pulumi-gcp/sdk/v5/go/gcp/container"
 	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/container"
 	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
-	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
-	schedulingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/scheduling/v1"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
@@ -19,48 +19,137 @@ var oauthScopes = []string{
 	"https://www.googleapis.com/auth/logging.write",
 }
 
-func CreateGKECluster(ctx *pulumi.Context, projectName string, region string, location string) (*container.Cluster, error) {
+func init() {
+	RegisterClusterProvider("gke", newGKEClusterProvider)
+}
+
+// GKESettings configures the GKE cluster and its node pools
+type GKESettings struct {
+	DefaultPool        utils.GKENodePool
+	RemoveDefaultPool  bool
+	NodePools          []utils.GKENodePool
+	ClusterAutoscaling utils.GKEClusterAutoscaling
+	// PrivateCluster keeps the nodes off the internet, PrivateEndpoint the control plane as well
+	PrivateCluster        bool
+	PrivateEndpoint       bool
+	MasterIpv4Cidr        string
+	MasterAuthorizedCidrs []string
+	// Version pins the control plane and nodes and turns off auto-upgrades, upgrades then follow the config
+	Version           string
+	UpgradeSettings   *utils.UpgradeSettings
+	MaintenanceWindow *utils.MaintenanceWindow
+	// Credentials is the gcp-credentials service account key the kube provider authenticates with, application default credentials when nil
+	Credentials *pulumi.StringOutput
+	// Project is the GCP project, whose <project>.svc.id.goog is the only workload identity pool GKE accepts
+	Project string
+}
+
+// gkeClusterProvider builds a GKE cluster on a GCP network
+type gkeClusterProvider struct {
+	settings       ClusterSettings
+	network        *NetworkResult
+	cluster        *container.Cluster
+	ingressAddress *IngressAddress
+}
+
+func newGKEClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &gkeClusterProvider{settings: settings}
+}
+
+func (p *gkeClusterProvider) CreateNetwork(ctx *pulumi.Context) (err error) {
+	p.network, err = CreateNetwork(ctx, p.settings.CloudProvider, p.settings.Region, p.settings.ProjectName, p.settings.FirewallRules)
+	if err != nil {
+		return err
+	}
+
+	if p.settings.Ingress.StaticIP {
+		p.ingressAddress, err = reserveGCPIngressAddress(ctx, p.settings.ProjectName, p.settings.Region, p.network, p.settings.Ingress.Internal)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Private nodes have no external IPs, so they need Cloud NAT to pull images
+	if p.settings.GKE.PrivateCluster {
+		return CreateGCPCloudNAT(ctx, p.settings.Region, p.settings.ProjectName, p.network)
+	}
+
+	return nil
+}
+
+func (p *gkeClusterProvider) IngressAddress() *IngressAddress {
+	return p.ingressAddress
+}
+
+func (p *gkeClusterProvider) CreateCluster(ctx *pulumi.Context) (err error) {
+	p.cluster, err = CreateGKECluster(ctx, p.settings.ProjectName, p.settings.Region, p.settings.Location, p.network, p.settings.GKE)
+	return err
+}
+
+func (p *gkeClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return CreateGKENodePools(ctx, p.settings.ProjectName, p.cluster, p.settings.Region, p.settings.Locations, p.settings.GKE)
+}
+
+func (p *gkeClusterProvider) GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	return NewGKEKubernetesProvider(ctx, p.cluster, p.settings.GKE.Credentials)
+}
+
+func CreateGKECluster(ctx *pulumi.Context, projectName string, region string, location string, network *NetworkResult, settings GKESettings) (*container.Cluster, error) {
 	// Create the GKE cluster
 	// Array of node locations
 
-	cluster, err := container.NewCluster(ctx, projectName, &container.ClusterArgs{
-		InitialNodeCount: pulumi.Int(3),
-		//RemoveDefaultNodePool: pulumi.Bool(true),
+	// Node auto-provisioning on top of the node pools, bounded by gke-cluster-autoscaling
+	clusterAutoscaling := &container.ClusterClusterAutoscalingArgs{
+		Enabled: pulumi.Bool(settings.ClusterAutoscaling.Enabled),
+	}
+	if settings.ClusterAutoscaling.Enabled {
+		clusterAutoscaling.ResourceLimits = container.ClusterClusterAutoscalingResourceLimitArray{
+			&container.ClusterClusterAutoscalingResourceLimitArgs{
+				Maximum:      pulumi.Int(settings.ClusterAutoscaling.MaxCpu),
+				Minimum:      pulumi.Int(settings.ClusterAutoscaling.MinCpu),
+				ResourceType: pulumi.String("cpu"),
+			},
+			&container.ClusterClusterAutoscalingResourceLimitArgs{
+				Maximum:      pulumi.Int(settings.ClusterAutoscaling.MaxMemoryGb),
+				Minimum:      pulumi.Int(settings.ClusterAutoscaling.MinMemoryGb),
+				ResourceType: pulumi.String("memory"),
+			},
+		}
+	}
+
+	clusterArgs := &container.ClusterArgs{
+		InitialNodeCount: pulumi.Int(settings.DefaultPool.MinNodes),
 		//Location: pulumi.String("us-east1-b"),
 		Location:           pulumi.String(location),
 		DeletionProtection: pulumi.Bool(false), // TODO: Source this from the config
 		MinMasterVersion:   pulumi.String("latest"),
-		ClusterAutoscaling: &container.ClusterClusterAutoscalingArgs{
-			Enabled: pulumi.Bool(true),
-			ResourceLimits: container.ClusterClusterAutoscalingResourceLimitArray{
-				&container.ClusterClusterAutoscalingResourceLimitArgs{
-					Maximum:      pulumi.Int(10),
-					Minimum:      pulumi.Int(1),
-					ResourceType: pulumi.String("cpu"),
-				},
-				&container.ClusterClusterAutoscalingResourceLimitArgs{
-					Maximum:      pulumi.Int(64),
-					Minimum:      pulumi.Int(1),
-					ResourceType: pulumi.String("memory"),
-				},
-			},
-		},
-		Network:    Network.ID(),
-		Subnetwork: Subnetwork.ID(),
+		ClusterAutoscaling: clusterAutoscaling,
+		Network:            network.NetworkId,
+		Subnetwork:         network.PrivateSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
 		//NodeLocations:    pulumi.ToStringArray(nodeLocations),
-		NodeConfig: &container.ClusterNodeConfigArgs{
-			MachineType: pulumi.String("n1-standard-1"), // TODO: Make this dynamic
-			DiskSizeGb:  pulumi.Int(30),                 // TODO: Make this dynamic/configurable
-			OauthScopes: pulumi.ToStringArray(oauthScopes),
-			Preemptible: pulumi.Bool(false),
-			WorkloadMetadataConfig: &container.ClusterNodeConfigWorkloadMetadataConfigArgs{
-				Mode: pulumi.String("GKE_METADATA"),
-			},
-		},
+		NodeConfig: gkeClusterNodeConfig(settings.DefaultPool),
 		WorkloadIdentityConfig: &container.ClusterWorkloadIdentityConfigArgs{
-			WorkloadPool: pulumi.String(projectName + ".svc.id.goog"),
+			WorkloadPool: pulumi.String(gkeWorkloadPool(settings.Project, projectName)),
 		},
-	})
+	}
+
+	// Only set when asked for so clusters created before this option don't see a diff
+	if settings.RemoveDefaultPool {
+		clusterArgs.RemoveDefaultNodePool = pulumi.Bool(true)
+	}
+
+	// A pinned version leaves the release channel so GKE stops moving the cluster on its own
+	if settings.Version != "" {
+		clusterArgs.MinMasterVersion = pulumi.String(settings.Version)
+		clusterArgs.NodeVersion = pulumi.String(settings.Version)
+		clusterArgs.ReleaseChannel = &container.ClusterReleaseChannelArgs{
+			Channel: pulumi.String("UNSPECIFIED"),
+		}
+	}
+
+	applyGKEControlPlaneSettings(clusterArgs, settings)
+
+	cluster, err := container.NewCluster(ctx, projectName, clusterArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -72,107 +161,174 @@ func CreateGKECluster(ctx *pulumi.Context, projectName string, region string, lo
 	return cluster, nil
 }
 
-func CreateGKENodePools(ctx *pulumi.Context, projectName string, cluster *container.Cluster, region string, locations []string) (err error) {
-	// Create the medium node pool
-	/*
-		_, err = container.NewNodePool(ctx, projectName+"-medium", &container.NodePoolArgs{
-			Cluster:       cluster.Name,
-			Location:      pulumi.String(location),
-			NodeCount:     pulumi.Int(1),
-			NodeLocations: pulumi.ToStringArray(nodeLocations),
-			NodeConfig: &container.NodePoolNodeConfigArgs{
-				MachineType: pulumi.String("n1-standard-2"),
-				DiskSizeGb:  pulumi.Int(30),
+// applyGKEControlPlaneSettings sets the options standard and Autopilot clusters share, each only when configured
+func applyGKEControlPlaneSettings(clusterArgs *container.ClusterArgs, settings GKESettings) {
+	// Automatic upgrades and repairs only start inside the window
+	if settings.MaintenanceWindow != nil {
+		clusterArgs.MaintenancePolicy = &container.ClusterMaintenancePolicyArgs{
+			RecurringWindow: &container.ClusterMaintenancePolicyRecurringWindowArgs{
+				StartTime:  pulumi.String(settings.MaintenanceWindow.StartTime),
+				EndTime:    pulumi.String(settings.MaintenanceWindow.EndTime),
+				Recurrence: pulumi.String(settings.MaintenanceWindow.Recurrence),
 			},
-		})
-		if err != nil {
-			return err
 		}
-	*/
+	}
 
-	// Create the small node pool
-	_, err = container.NewNodePool(ctx, projectName+"-small", &container.NodePoolArgs{
-		Cluster: cluster.Name,
-		//Location: pulumi.String("us-east1-b"),
-		Location:  pulumi.String(region),
-		NodeCount: pulumi.Int(1),
-		//NodeLocations: pulumi.ToStringArray(nodeLocations),
-		NodeLocations: pulumi.ToStringArray(locations),
-		NodeConfig: &container.NodePoolNodeConfigArgs{
-			MachineType: pulumi.String("n1-standard-1"),
-			DiskSizeGb:  pulumi.Int(30),
-		},
-	})
-	if err != nil {
-		return err
+	// Private clusters have to be VPC native, an empty allocation policy lets GKE pick the pod and service ranges
+	if settings.PrivateCluster {
+		clusterArgs.PrivateClusterConfig = &container.ClusterPrivateClusterConfigArgs{
+			EnablePrivateNodes:    pulumi.Bool(true),
+			EnablePrivateEndpoint: pulumi.Bool(settings.PrivateEndpoint),
+			MasterIpv4CidrBlock:   pulumi.String(settings.MasterIpv4Cidr),
+		}
+		clusterArgs.IpAllocationPolicy = &container.ClusterIpAllocationPolicyArgs{}
 	}
 
-	return nil
+	// Limit who can reach the control plane, whitelist-ip only covers the VM firewall
+	if len(settings.MasterAuthorizedCidrs) > 0 {
+		cidrBlocks := container.ClusterMasterAuthorizedNetworksConfigCidrBlockArray{}
+		for _, cidr := range settings.MasterAuthorizedCidrs {
+			cidrBlocks = append(cidrBlocks, &container.ClusterMasterAuthorizedNetworksConfigCidrBlockArgs{
+				CidrBlock:   pulumi.String(cidr),
+				DisplayName: pulumi.String(cidr),
+			})
+		}
+		clusterArgs.MasterAuthorizedNetworksConfig = &container.ClusterMasterAuthorizedNetworksConfigArgs{
+			CidrBlocks: cidrBlocks,
+		}
+	}
 }
 
-func CreateGKEKubePriorities(ctx *pulumi.Context, cluster *container.Cluster, kubeProvider *kubernetes.Provider) (err error) {
-	_, err = schedulingv1.NewPriorityClass(ctx, "high-priority", &schedulingv1.PriorityClassArgs{
-		Value: pulumi.Int(100000), // High priority value
-		Metadata: &metav1.ObjectMetaArgs{
-			Name: pulumi.String("high-priority"),
+// gkeClusterNodeConfig builds the default pool node config, optional fields are left unset when not configured
+func gkeClusterNodeConfig(pool utils.GKENodePool) *container.ClusterNodeConfigArgs {
+	nodeConfig := &container.ClusterNodeConfigArgs{
+		MachineType: pulumi.String(pool.MachineType),
+		DiskSizeGb:  pulumi.Int(pool.DiskSizeGb),
+		OauthScopes: pulumi.ToStringArray(oauthScopes),
+		Preemptible: pulumi.Bool(pool.Preemptible),
+		WorkloadMetadataConfig: &container.ClusterNodeConfigWorkloadMetadataConfigArgs{
+			Mode: pulumi.String("GKE_METADATA"),
 		},
-		Description:      pulumi.String("This is a high priority class"),
-		GlobalDefault:    pulumi.Bool(false),
-		PreemptionPolicy: pulumi.String("PreemptLowerPriority"),
-	}, pulumi.Provider(kubeProvider))
-	if err != nil {
-		return err
+	}
+	if pool.DiskType != "" {
+		nodeConfig.DiskType = pulumi.String(pool.DiskType)
+	}
+	if pool.Spot {
+		nodeConfig.Spot = pulumi.Bool(true)
+	}
+	if len(pool.Labels) > 0 {
+		nodeConfig.Labels = pulumi.ToStringMap(pool.Labels)
+	}
+	taints := container.ClusterNodeConfigTaintArray{}
+	for _, taint := range pool.Taints {
+		taints = append(taints, &container.ClusterNodeConfigTaintArgs{
+			Key:    pulumi.String(taint.Key),
+			Value:  pulumi.String(taint.Value),
+			Effect: pulumi.String(taint.APIEffect()),
+		})
+	}
+	if len(taints) > 0 {
+		nodeConfig.Taints = taints
 	}
 
-	return nil
+	return nodeConfig
 }
 
-func NewGKEKubernetesProvider(ctx *pulumi.Context, cluster *container.Cluster) (*kubernetes.Provider, error) {
-	// Create a kubeconfig string
-	//masterAuth := cluster.MasterAuth.ClusterCaCertificate()
-	kubeConfig := pulumi.All(cluster.Name, cluster.Endpoint, cluster.MasterAuth).ApplyT(func(args []interface{}) (pulumi.StringOutput, error) {
-		masterAuth := args[2].(container.ClusterMasterAuth)
-		//fmt.Printf("(pall) masterAuth: %v\n", masterAuth)
+func CreateGKENodePools(ctx *pulumi.Context, projectName string, cluster *container.Cluster, region string, locations []string, settings GKESettings) (err error) {
+	for _, pool := range settings.NodePools {
+		nodeLocations := locations
+		if len(pool.Zones) > 0 {
+			nodeLocations = pool.Zones
+		}
 
-		//bytes := []byte(*masterAuth.ClusterCaCertificate)
+		nodeConfig := &container.NodePoolNodeConfigArgs{
+			MachineType: pulumi.String(pool.MachineType),
+			DiskSizeGb:  pulumi.Int(pool.DiskSizeGb),
+		}
+		if pool.DiskType != "" {
+			nodeConfig.DiskType = pulumi.String(pool.DiskType)
+		}
+		if pool.Spot {
+			nodeConfig.Spot = pulumi.Bool(true)
+		}
+		if pool.Preemptible {
+			nodeConfig.Preemptible = pulumi.Bool(true)
+		}
+		if len(pool.Labels) > 0 {
+			nodeConfig.Labels = pulumi.ToStringMap(pool.Labels)
+		}
+		taints := container.NodePoolNodeConfigTaintArray{}
+		for _, taint := range pool.Taints {
+			taints = append(taints, &container.NodePoolNodeConfigTaintArgs{
+				Key:    pulumi.String(taint.Key),
+				Value:  pulumi.String(taint.Value),
+				Effect: pulumi.String(taint.APIEffect()),
+			})
+		}
+		if len(taints) > 0 {
+			nodeConfig.Taints = taints
+		}
 
-		// encode the byte slice in base64
-		//clusterCaCertificate := base64.StdEncoding.EncodeToString(bytes)
-		//clusterCaCertificate := masterAuth.ClusterCaCertificate
-		clusterCaCertificate := *masterAuth.ClusterCaCertificate
-		//fmt.Printf("(pall) clusterCaCertificate: %s\n", clusterCaCertificate)
+		nodePoolArgs := &container.NodePoolArgs{
+			Cluster:       cluster.Name,
+			Location:      pulumi.String(region),
+			NodeLocations: pulumi.ToStringArray(nodeLocations),
+			NodeConfig:    nodeConfig,
+		}
 
-		clusterEndpoint := args[1].(string)
-		//fmt.Printf("(pall) clusterEndpoint: %s\n", clusterEndpoint)
+		// Pinned pools only change version when the config does
+		if settings.Version != "" {
+			nodePoolArgs.Version = pulumi.String(settings.Version)
+			nodePoolArgs.Management = &container.NodePoolManagementArgs{
+				AutoRepair:  pulumi.Bool(true),
+				AutoUpgrade: pulumi.Bool(false),
+			}
+		}
+		if settings.UpgradeSettings != nil {
+			nodePoolArgs.UpgradeSettings = &container.NodePoolUpgradeSettingsArgs{
+				Strategy:       pulumi.String("SURGE"),
+				MaxSurge:       pulumi.Int(settings.UpgradeSettings.MaxSurge),
+				MaxUnavailable: pulumi.Int(settings.UpgradeSettings.MaxUnavailable),
+			}
+		}
 
-		clusterName := args[0].(string)
-		//fmt.Printf("(pall) clusterName: %s\n", clusterName)
+		// Node counts are per zone, autoscaling pools start at their minimum
+		if pool.Autoscaling() {
+			nodePoolArgs.InitialNodeCount = pulumi.Int(pool.MinNodes)
+			nodePoolArgs.Autoscaling = &container.NodePoolAutoscalingArgs{
+				MinNodeCount: pulumi.Int(pool.MinNodes),
+				MaxNodeCount: pulumi.Int(pool.MaxNodes),
+			}
+		} else {
+			nodePoolArgs.NodeCount = pulumi.Int(pool.MinNodes)
+		}
 
-		kubeConfig := generateKubeconfig(
-			clusterEndpoint,
-			clusterName,
-			clusterCaCertificate,
-		)
+		_, err = container.NewNodePool(ctx, projectName+"-"+pool.Name, nodePoolArgs)
+		if err != nil {
+			return err
+		}
+	}
 
-		//fmt.Printf("(pall) Args[0]: %v\n", args[0])
-		//fmt.Printf("(pall) Args[1]: %v\n", args[1])
+	return nil
+}
 
-		kubeConfig.ApplyT(func(s string) string {
-			//fmt.Printf("(pall) kubeConfig: %s\n", s)
-			return s
-		})
+func NewGKEKubernetesProvider(ctx *pulumi.Context, cluster *container.Cluster, credentials *pulumi.StringOutput) (*kubernetes.Provider, error) {
+	credentialsJSON := pulumi.String("").ToStringOutput()
+	if credentials != nil {
+		credentialsJSON = *credentials
+	}
 
-		//fmt.Printf("(pall) KubeConfig: %v\n", kubeConfig)
-		//fmt.Printf("Args[2]: %v", args[2])
-		//masterAuth := args[2].(container.ClusterMasterAuth)
-		//clusterCaCertificate := masterAuth.ClusterCaCertificate
-		//fmt.Printf("Args[2].ClusterCaCertificate: %v", clusterCaCertificate)
+	// Tokens are minted by the exec plugin whenever the provider needs one, so the kubeconfig in state never expires
+	kubeConfig := pulumi.All(cluster.Name, cluster.Endpoint, cluster.MasterAuth, credentialsJSON).ApplyT(func(args []interface{}) string {
+		clusterName := args[0].(string)
+		clusterEndpoint := args[1].(string)
+		masterAuth := args[2].(container.ClusterMasterAuth)
 
-		return kubeConfig, nil
-	})
+		return generateKubeconfig(clusterEndpoint, clusterName, *masterAuth.ClusterCaCertificate, args[3].(string))
+	}).(pulumi.StringOutput)
 
 	kubeProvider, err := kubernetes.NewProvider(ctx, "GKEk8sProvider", &kubernetes.ProviderArgs{
-		Kubeconfig: kubeConfig.(pulumi.StringOutput),
+		Kubeconfig: pulumi.ToSecret(kubeConfig).(pulumi.StringOutput),
 	})
 	if err != nil {
 		return nil, err
@@ -182,15 +338,20 @@ func NewGKEKubernetesProvider(ctx *pulumi.Context, cluster *container.Cluster) (
 	return kubeProvider, nil
 }
 
-func generateKubeconfig(clusterEndpoint string, clusterName string,
-	clusterCaCertificate string) pulumi.StringOutput {
-	//context := pulumi.Sprintf("dimo_%s", clusterName).ToStringOutput()
+// generateKubeconfig builds a kubeconfig for the cluster that authenticates through the gke-credentials exec plugin.
+// credentialsJSON is handed to the plugin when set, otherwise it uses application default credentials.
+func generateKubeconfig(clusterEndpoint string, clusterName string, clusterCaCertificate string, credentialsJSON string) string {
 	context := clusterName
 
-	//fmt.Printf("(gen config) clusterCaCertificate: %s\n", clusterCaCertificate)
-	//fmt.Printf("(gen config) clusterEndpoint: %s\n", clusterEndpoint)
+	env := ""
+	if credentialsJSON != "" {
+		env = fmt.Sprintf(`
+      env:
+      - name: %s
+        value: %s`, utils.GKECredentialsEnv, base64.StdEncoding.EncodeToString([]byte(credentialsJSON)))
+	}
 
-	kubeConfig := fmt.Sprintf(`apiVersion: v1
+	return fmt.Sprintf(`apiVersion: v1
 clusters:
 - cluster:
     certificate-authority-data: %s
@@ -209,56 +370,10 @@ users:
   user:
     exec:
       apiVersion: client.authentication.k8s.io/v1beta1
-      command: gke-gcloud-auth-plugin
-      installHint: Install gke-gcloud-auth-plugin for use with kubectl by following
-        https://cloud.google.com/blog/products/containers-kubernetes/kubectl-auth-changes-in-gke
-      provideClusterInfo: true`,
-		clusterCaCertificate, clusterEndpoint, context, context, context, context, context, context)
-
-	//kubeConfig = strings.Replace(kubeConfig, "\t", "  ", -1)
-
-	/*
-		pulumi.String(kubeConfig).ApplyT(func(s string) string {
-			fmt.Printf("(gen config) kubeConfig: %s\n", s)
-			return s
-		})
-	*/
-
-	//fmt.Printf("(gen config) kubeConfig: %s\n", kubeConfig)
-	//fmt.Printf("(gen config) kubeConfig: %s\n\n", kubeConfig.(pulumi.StringOutput))
-
-	// Convert kubeConfig to pulumi.StringOutput
-	kubeConfigOutput := pulumi.String(kubeConfig)
-
-	return kubeConfigOutput.ToStringOutput()
-
-	/*
-	     return pulumi.Sprintf(`apiVersion: v1
-	   clusters:
-	   - cluster:
-	       certificate-authority-data: %s
-	       server: https://%s
-	     name: %s
-	   contexts:
-	   - context:
-	       cluster: %s
-	       user: %s
-	     name: %s
-	   current-context: %s
-	   kind: Config
-	   preferences: {}
-	   users:
-	   - name: %s
-	     user:
-	       exec:
-	         apiVersion: client.authentication.k8s.io/v1beta1
-	         command: gke-gcloud-auth-plugin
-	         installHint: Install gke-gcloud-auth-plugin for use with kubectl by following
-	           https://cloud.google.com/blog/products/containers-kubernetes/kubectl-auth-changes-in-gke
-	         provideClusterInfo: true
-	   `, clusterCaCertificate, clusterEndpoint, context, context, context, context, context, context)
-	*/
-
+      command: gke-credentials
+      installHint: Install the credential helper with go install ./cmd/gke-credentials
+      interactiveMode: Never%s`,
+		clusterCaCertificate, clusterEndpoint, context, context, context, context, context, context, env)
 }
 
 /*
@@ -300,3 +415,12 @@ users:
 //ctx.Export("kubeconfig", kubeconfig)
 
 // Create the Kubernetes provider
+
+// gkeWorkloadPool is the workload identity pool the GSA bindings in dependencies name, <gcp-project>.svc.id.goog.
+// Stacks without gcp-project fall back to the project name, which only ever worked when the two matched.
+func gkeWorkloadPool(gcpProject string, projectName string) string {
+	if gcpProject == "" {
+		gcpProject = projectName
+	}
+	return gcpProject + ".svc.id.goog"
+}
@@ -0,0 +1,57 @@
+package infrastructure
+
+import (
+	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/container"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	RegisterClusterProvider("gke-autopilot", newGKEAutopilotClusterProvider)
+}
+
+// gkeAutopilotClusterProvider builds a GKE Autopilot cluster. Google manages the nodes, so apart from the
+// cluster itself and its lack of node pools it is built and connected to like a standard GKE cluster.
+type gkeAutopilotClusterProvider struct {
+	gkeClusterProvider
+}
+
+func newGKEAutopilotClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &gkeAutopilotClusterProvider{gkeClusterProvider{settings: settings}}
+}
+
+func (p *gkeAutopilotClusterProvider) CreateCluster(ctx *pulumi.Context) (err error) {
+	p.cluster, err = CreateGKEAutopilotCluster(ctx, p.settings.ProjectName, p.settings.Region, p.network, p.settings.GKE)
+	return err
+}
+
+// CreateNodePools has nothing to do, Autopilot sizes the nodes from the pods' resource requests
+func (p *gkeAutopilotClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return nil
+}
+
+// CreateGKEAutopilotCluster creates a regional Autopilot cluster. Workload identity is always on, and the
+// cluster stays on its release channel, so a pinned version only sets the minimum control plane version.
+func CreateGKEAutopilotCluster(ctx *pulumi.Context, projectName string, region string, network *NetworkResult, settings GKESettings) (*container.Cluster, error) {
+	clusterArgs := &container.ClusterArgs{
+		Location:           pulumi.String(region),
+		EnableAutopilot:    pulumi.Bool(true),
+		DeletionProtection: pulumi.Bool(false), // TODO: Source this from the config
+		Network:            network.NetworkId,
+		Subnetwork:         network.PrivateSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
+		IpAllocationPolicy: &container.ClusterIpAllocationPolicyArgs{},
+	}
+	if settings.Version != "" {
+		clusterArgs.MinMasterVersion = pulumi.String(settings.Version)
+	}
+
+	applyGKEControlPlaneSettings(clusterArgs, settings)
+
+	cluster, err := container.NewCluster(ctx, projectName, clusterArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Export("clusterEndpoint", cluster.Endpoint)
+
+	return cluster, nil
+}
@@ -1,8 +1,12 @@
 package infrastructure
 
 import (
+	"fmt"
+
 	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
 	"github.com/pulumi/pulumi-command/sdk/go/command/remote"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
 
 	//"github.com/pulumi/pulumi-gcp/sdk/v5/go/gcp/compute"
 	"github.com/pulumi/pulumi-gcp/sdk/v7/go/gcp/compute"
@@ -10,43 +14,306 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
+func init() {
+	RegisterClusterProvider("k3s", newK3sClusterProvider)
+}
+
+// K3sSettings configures the shape of a k3s cluster, the counts are validated up front by utils.LoadNodeConfig
+type K3sSettings struct {
+	ServerCount int
+	AgentCount  int
+	// HA runs embedded etcd across the servers instead of sqlite on a single server
+	HA bool
+	// SSHKeyVersion rotates the generated SSH key whenever it changes
+	SSHKeyVersion string
+	// Host is rendered into the user-data every VM boots with, and pins the k3s version and flags
+	Host utils.K3sHostConfig
+}
+
+// k3sClusterProvider builds one or more VMs and installs k3s on them over SSH
+type k3sClusterProvider struct {
+	settings ClusterSettings
+	network  *NetworkResult
+	servers  []*k3sHost
+	agents   []*k3sHost
+	sshKey   *SSHKeyPair
+}
+
+// k3sHost is the cloud neutral view of a VM k3s gets installed on
+type k3sHost struct {
+	name       string
+	instance   pulumi.Resource
+	instanceId pulumi.StringOutput
+	publicIp   pulumi.StringOutput
+	internalIp pulumi.StringOutput
+}
+
+func newK3sClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &k3sClusterProvider{settings: settings}
+}
+
+// multiNode is false for the original single VM layout, which keeps its install command and resource names unchanged
+func (p *k3sClusterProvider) multiNode() bool {
+	return p.settings.K3s.ServerCount > 1 || p.settings.K3s.AgentCount > 0 || p.settings.K3s.HA
+}
+
+func (p *k3sClusterProvider) CreateNetwork(ctx *pulumi.Context) (err error) {
+	p.network, err = CreateNetwork(ctx, p.settings.CloudProvider, p.settings.Region, p.settings.ProjectName, p.settings.FirewallRules)
+	return err
+}
+
+func (p *k3sClusterProvider) CreateCluster(ctx *pulumi.Context) error {
+	// Generate the SSH key pulumi connects to the hosts with
+	sshKey, err := CreateSSHKeyPair(ctx, p.settings.K3s.SSHKeyVersion)
+	if err != nil {
+		return err
+	}
+	p.sshKey = sshKey
+
+	// The first server keeps the original resource names so single node stacks are not replaced
+	names := []string{"instance"}
+	for i := 1; i < p.settings.K3s.ServerCount; i++ {
+		names = append(names, fmt.Sprintf("k3s-server-%d", i))
+	}
+	for i := 0; i < p.settings.K3s.AgentCount; i++ {
+		names = append(names, fmt.Sprintf("k3s-agent-%d", i))
+	}
+
+	var hosts []*k3sHost
+	switch p.settings.CloudProvider {
+	case "gcp":
+		if p.multiNode() {
+			err = createK3sInternalFirewallGCP(ctx, p.network)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i, name := range names {
+			inst, err := CreateK3sCluster(ctx, name, p.network, sshKey.PublicKey, p.userData(sshKey.PublicKey, false), i == 0)
+			if err != nil {
+				return err
+			}
+
+			// Note that .Elem() essentially dereferences the output pointer to give us an unwrapped value we can use
+			accessConfigs := inst.NetworkInterfaces.Index(pulumi.Int(0)).AccessConfigs()
+			hosts = append(hosts, &k3sHost{
+				name:       name,
+				instance:   inst,
+				instanceId: inst.Name,
+				publicIp:   accessConfigs.Index(pulumi.Int(0)).NatIp().Elem(),
+				internalIp: inst.NetworkInterfaces.Index(pulumi.Int(0)).NetworkIp().Elem(),
+			})
+		}
+	case "aws":
+		securityGroupIds := p.network.FirewallIds
+		if p.multiNode() {
+			internalSg, err := createK3sInternalSecurityGroupAWS(ctx, p.network)
+			if err != nil {
+				return err
+			}
+			securityGroupIds = append(pulumi.StringArray{internalSg.ID()}, securityGroupIds...)
+		}
+
+		for i, name := range names {
+			inst, publicIp, err := CreateK3sClusterAWS(ctx, name, p.settings.ProjectName, p.network, securityGroupIds, p.userData(sshKey.PublicKey, true), i == 0)
+			if err != nil {
+				return err
+			}
+
+			hosts = append(hosts, &k3sHost{
+				name:       name,
+				instance:   inst,
+				instanceId: inst.ID().ToStringOutput(),
+				publicIp:   publicIp,
+				internalIp: inst.PrivateIp,
+			})
+		}
+	default:
+		return fmt.Errorf("cloud provider %s not supported for k3s", p.settings.CloudProvider)
+	}
+
+	p.servers = hosts[:p.settings.K3s.ServerCount]
+	p.agents = hosts[p.settings.K3s.ServerCount:]
+
+	return nil
+}
+
+// k3s nodes are joined in GetKubeProvider so there are no node pools to create
+func (p *k3sClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return nil
+}
+
+func (p *k3sClusterProvider) GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	firstServer := p.servers[0]
+
+	var token pulumi.StringOutput
+	if p.multiNode() {
+		// Every node joins the cluster with this token, it is stored in state as a secret
+		clusterToken, err := random.NewRandomPassword(ctx, "k3s-token", &random.RandomPasswordArgs{
+			Length:  pulumi.Int(48),
+			Special: pulumi.Bool(false),
+		})
+		if err != nil {
+			return nil, err
+		}
+		token = clusterToken.Result
+	}
+
+	// Install the first server, every other node joins through it
+	connection, err := GetKubeHostConnection(ctx, firstServer.publicIp, p.sshKey.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	firstInstall, err := InstallK3sNode(ctx, "k3sinstall", connection, firstServer.instance,
+		p.k3sInstallCommand(firstServer, nil, token, "server"), nil, p.installOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, server := range p.servers[1:] {
+		err = p.joinK3sNode(ctx, server, firstServer, token, "server", firstInstall)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, agent := range p.agents {
+		err = p.joinK3sNode(ctx, agent, firstServer, token, "agent", firstInstall)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kubeConfig, err := GetKubeConfigForK3s(ctx, connection, firstServer.instance, firstInstall, firstServer.publicIp)
+	if err != nil {
+		return nil, err
+	}
+
+	k3sProvider, err := NewKubeProviderForK3s(ctx, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.settings.K3s.Host.UpgradeController {
+		err = InstallK3sUpgradePlans(ctx, k3sProvider, p.settings.K3s.Host.Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx.Export("instanceName", firstServer.instanceId)
+	ctx.Export("publicIp", firstServer.publicIp)
+	ctx.Export("internalIp", firstServer.internalIp)
+
+	return k3sProvider, nil
+}
+
+func (p *k3sClusterProvider) joinK3sNode(ctx *pulumi.Context, host *k3sHost, firstServer *k3sHost, token pulumi.StringOutput, role string, firstInstall *remote.Command) error {
+	connection, err := GetKubeHostConnection(ctx, host.publicIp, p.sshKey.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = InstallK3sNode(ctx, fmt.Sprintf("%s-k3sinstall", host.name), connection, host.instance,
+		p.k3sInstallCommand(host, firstServer, token, role), []pulumi.Resource{firstInstall}, p.installOptions()...)
+
+	return err
+}
+
+// installOptions stops version bumps from re-running the install once the upgrade controller owns upgrades.
+// New nodes still install the pinned version, the plans move the running ones.
+func (p *k3sClusterProvider) installOptions() []pulumi.ResourceOption {
+	if p.settings.K3s.Host.UpgradeController {
+		return []pulumi.ResourceOption{pulumi.IgnoreChanges([]string{"create"})}
+	}
+	return nil
+}
+
+// userData renders the bootstrap script a VM boots with. cloudInit is set on AWS, where cloud-init runs the
+// script and nothing creates the ssh user from metadata.
+func (p *k3sClusterProvider) userData(pubKey pulumi.StringOutput, cloudInit bool) pulumi.StringOutput {
+	return pubKey.ApplyT(func(pubKey string) (string, error) {
+		return renderK3sUserData(k3sUserData{
+			User:       sshUser,
+			PublicKey:  pubKey,
+			CreateUser: cloudInit,
+			EveryBoot:  cloudInit,
+			Host:       p.settings.K3s.Host,
+		})
+	}).(pulumi.StringOutput)
+}
+
+// k3sInstallCommand builds the get.k3s.io command for a node, joinServer is nil for the first server
+func (p *k3sClusterProvider) k3sInstallCommand(host *k3sHost, joinServer *k3sHost, token pulumi.StringOutput, role string) pulumi.StringOutput {
+	joinIp := pulumi.String("").ToStringOutput()
+	if joinServer != nil {
+		joinIp = joinServer.internalIp
+	}
+	// Single node installs have no token
+	if !p.multiNode() {
+		token = pulumi.String("").ToStringOutput()
+	}
+
+	return pulumi.All(host.internalIp, host.publicIp, joinIp, token).ApplyT(func(args []interface{}) string {
+		return renderK3sInstallCommand(k3sInstall{
+			Role:       role,
+			MultiNode:  p.multiNode(),
+			HA:         p.settings.K3s.HA,
+			Host:       p.settings.K3s.Host,
+			InternalIp: args[0].(string),
+			PublicIp:   args[1].(string),
+			JoinIp:     args[2].(string),
+			Token:      args[3].(string),
+		})
+	}).(pulumi.StringOutput)
+}
+
 func CreateK3sCluster(
 	ctx *pulumi.Context,
-	network *compute.Network,
-	subnetwork *compute.Subnetwork) (
+	name string,
+	network *NetworkResult,
+	pubKey pulumi.StringOutput,
+	userData pulumi.StringOutput,
+	staticIp bool) (
 	*compute.Instance,
 	error) {
-	instanceTags := utils.ToPulumiStringArray([]string{"dimo"})
-
-	const metadataStartupScript = `#!/bin/bash sudo apt-get update && sudo apt install -y jq`
+	// The ssh user comes from the ssh-keys entry, the startup-script runs the user-data bootstrap on every boot
+	metadata := gcpSSHKeysMetadata(pubKey)
+	metadata["startup-script"] = userData
 
-	// Reserve a new public IP
-	publicAddress, err := compute.NewAddress(ctx, "publicip1", nil)
-	if err != nil {
-		return nil, err
+	// Only the first server needs a reserved public IP, the kubeconfig points at it
+	accessConfig := &compute.InstanceNetworkInterfaceAccessConfigArgs{}
+	var dependsOn []pulumi.Resource
+	if staticIp {
+		publicAddress, err := compute.NewAddress(ctx, "publicip1", nil)
+		if err != nil {
+			return nil, err
+		}
+		accessConfig.NatIp = publicAddress.Address // Can use NatIp: pulumi.String("") to get an ephemeral IP
+		dependsOn = append(dependsOn, publicAddress)
 	}
 
-	inst, err := compute.NewInstance(ctx, "instance", &compute.InstanceArgs{
+	inst, err := compute.NewInstance(ctx, name, &compute.InstanceArgs{
 		BootDisk: &compute.InstanceBootDiskArgs{
 			InitializeParams: &compute.InstanceBootDiskInitializeParamsArgs{
 				Image: pulumi.String(osImage),
 			},
 		},
-		MachineType:           pulumi.String("n1-standard-1"),
-		Tags:                  instanceTags,
-		MetadataStartupScript: pulumi.String(metadataStartupScript),
+		MachineType: pulumi.String("n1-standard-1"),
+		Tags:        network.InstanceTags,
+		Metadata:    metadata,
 		NetworkInterfaces: &compute.InstanceNetworkInterfaceArray{
 			&compute.InstanceNetworkInterfaceArgs{
-				Network: network.ID(),
+				Network: network.NetworkId,
 				AccessConfigs: compute.InstanceNetworkInterfaceAccessConfigArray{
-					&compute.InstanceNetworkInterfaceAccessConfigArgs{
-						NatIp: publicAddress.Address, // Can use NatIp: pulumi.String("") to get an ephemeral IP
-					},
+					accessConfig,
 				},
-				Subnetwork: subnetwork.ID(),
+				Subnetwork: network.PublicSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
 			},
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{publicAddress}))
+	}, pulumi.DependsOn(dependsOn))
 	if err != nil {
 		return nil, err
 	}
@@ -54,46 +321,152 @@ func CreateK3sCluster(
 	return inst, nil
 }
 
+// Allow the k3s nodes to reach each other on every port (etcd, kubelet, flannel...)
+func createK3sInternalFirewallGCP(ctx *pulumi.Context, network *NetworkResult) error {
+	_, err := compute.NewFirewall(ctx, "k3s-internal", &compute.FirewallArgs{
+		Network: network.NetworkId,
+		Allows: compute.FirewallAllowArray{
+			&compute.FirewallAllowArgs{
+				Protocol: pulumi.String("all"),
+			},
+		},
+		Direction:  pulumi.String("INGRESS"),
+		SourceTags: network.InstanceTags,
+		TargetTags: network.InstanceTags,
+	})
+
+	return err
+}
+
+func CreateK3sClusterAWS(
+	ctx *pulumi.Context,
+	name string,
+	projectName string,
+	network *NetworkResult,
+	securityGroupIds pulumi.StringArray,
+	userData pulumi.StringOutput,
+	staticIp bool) (
+	*ec2.Instance,
+	pulumi.StringOutput,
+	error) {
+	// Look up the same Debian release the GCP instance runs
+	ami, err := ec2.LookupAmi(ctx, &ec2.LookupAmiArgs{
+		MostRecent: pulumi.BoolRef(true),
+		Owners:     []string{awsDebianOwner},
+		Filters: []ec2.GetAmiFilter{
+			{
+				Name:   "name",
+				Values: []string{awsDebianImage},
+			},
+			{
+				Name:   "architecture",
+				Values: []string{"x86_64"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	// The ssh key comes from the user-data, so rotating it restarts the instance instead of replacing it.
+	// Instances launched with the old EC2 key pair keep it, keyName would replace them.
+	inst, err := ec2.NewInstance(ctx, name, &ec2.InstanceArgs{
+		Ami:                 pulumi.String(ami.Id),
+		InstanceType:        pulumi.String("t3.medium"),
+		SubnetId:            network.PublicSubnetIds.ToStringArrayOutput().Index(pulumi.Int(0)),
+		VpcSecurityGroupIds: securityGroupIds,
+		UserData:            userData,
+		RootBlockDevice: &ec2.InstanceRootBlockDeviceArgs{
+			VolumeSize: pulumi.Int(30),
+			VolumeType: pulumi.String("gp3"),
+		},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.Sprintf("%s-%s", projectName, name),
+		},
+	}, pulumi.IgnoreChanges([]string{"keyName"}))
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	if !staticIp {
+		return inst, inst.PublicIp, nil
+	}
+
+	// Attach an Elastic IP so the kubeconfig server address survives instance restarts
+	publicAddress, err := ec2.NewEip(ctx, "publicip1", &ec2.EipArgs{
+		Domain:   pulumi.String("vpc"),
+		Instance: inst.ID(),
+	})
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	return inst, publicAddress.PublicIp, nil
+}
+
+// Allow the k3s nodes to reach each other on every port (etcd, kubelet, flannel...)
+func createK3sInternalSecurityGroupAWS(ctx *pulumi.Context, network *NetworkResult) (*ec2.SecurityGroup, error) {
+	return ec2.NewSecurityGroup(ctx, "k3s-internal-sg", &ec2.SecurityGroupArgs{
+		VpcId:       network.NetworkId,
+		Description: pulumi.String("Allow k3s nodes to reach each other"),
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol: pulumi.String("-1"),
+				FromPort: pulumi.Int(0),
+				ToPort:   pulumi.Int(0),
+				Self:     pulumi.Bool(true),
+			},
+		},
+	})
+}
+
 func GetKubeHostConnection(
 	ctx *pulumi.Context,
 	publicIp pulumi.StringOutput,
-	sshPrivKey string,
+	sshPrivKey pulumi.StringOutput,
 ) (remote.ConnectionArgs, error) {
 	// Create remote connection
 	connection := remote.ConnectionArgs{
 		Host:       publicIp,
-		PrivateKey: pulumi.String(string(sshPrivKey)),
-		User:       pulumi.String("pulumi"),
+		PrivateKey: sshPrivKey,
+		User:       pulumi.String(sshUser),
 	}
 
 	return connection, nil
 }
 
-func GetKubeConfigForK3s(
+func InstallK3sNode(
 	ctx *pulumi.Context,
+	name string,
 	connection remote.ConnectionArgs,
-	inst *compute.Instance) (*pulumi.StringOutput, error) {
-	internalIp := inst.NetworkInterfaces.Index(pulumi.Int(0)).NetworkIp().Elem()
-	accessConfigs := inst.NetworkInterfaces.Index(pulumi.Int(0)).AccessConfigs()
-	publicIp := accessConfigs.Index(pulumi.Int(0)).NatIp().Elem()
-
-	k3sCmdString := pulumi.Sprintf("curl -sfL https://get.k3s.io | sh -s -- --bind-address %s --tls-san %s --advertise-address %s --advertise-address %s --disable servicelb --write-kubeconfig-mode=644", internalIp, publicIp, internalIp, internalIp)
-
-	_, err := remote.NewCommand(ctx, "k3sinstall", &remote.CommandArgs{
-		Create:     k3sCmdString,
+	inst pulumi.Resource,
+	installCmd pulumi.StringOutput,
+	dependsOn []pulumi.Resource,
+	opts ...pulumi.ResourceOption) (*remote.Command, error) {
+	k3sInstall, err := remote.NewCommand(ctx, name, &remote.CommandArgs{
+		Create:     installCmd,
 		Connection: connection,
-	}, pulumi.DependsOn([]pulumi.Resource{inst}))
+	}, append(opts, pulumi.DependsOn(append([]pulumi.Resource{inst}, dependsOn...)))...)
 	if err != nil {
 		return nil, err
 	}
 
+	return k3sInstall, nil
+}
+
+func GetKubeConfigForK3s(
+	ctx *pulumi.Context,
+	connection remote.ConnectionArgs,
+	inst pulumi.Resource,
+	k3sInstall *remote.Command,
+	publicIp pulumi.StringOutput) (*pulumi.StringOutput, error) {
 	getKubeConfigCmd := pulumi.Sprintf("sudo cat /etc/rancher/k3s/k3s.yaml | sed \"s/.*server: .*/    server: https:\\/\\/%s:6443/g\"", publicIp)
 
 	getKubeConfig, err := remote.NewCommand(ctx, "getkubeconfig", &remote.CommandArgs{
 		Create:     getKubeConfigCmd,
 		Update:     getKubeConfigCmd,
 		Connection: connection,
-	}, pulumi.DependsOn([]pulumi.Resource{inst}))
+	}, pulumi.DependsOn([]pulumi.Resource{inst, k3sInstall}))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,193 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	RegisterClusterProvider("kind", newKindClusterProvider)
+	RegisterClusterProvider("k3d", newK3dClusterProvider)
+}
+
+// LocalClusterSettings configures kind and k3d clusters running in docker on the local machine
+type LocalClusterSettings struct {
+	Registry     bool // Run a local image registry the cluster pulls from
+	RegistryPort int
+	HttpPort     int // Host port mapped to the nginx ingress on 80
+	HttpsPort    int // Host port mapped to the nginx ingress on 443
+}
+
+// localClusterProvider builds a kind or k3d cluster with the kind/k3d CLI, there is no network to create
+type localClusterProvider struct {
+	settings ClusterSettings
+	tool     string
+	registry *local.Command
+	cluster  *local.Command
+}
+
+func newKindClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &localClusterProvider{settings: settings, tool: "kind"}
+}
+
+func newK3dClusterProvider(settings ClusterSettings) ClusterProvider {
+	return &localClusterProvider{settings: settings, tool: "k3d"}
+}
+
+// clusterName is the kind/k3d cluster name, falling back to dimo when no project-name is set
+func (p *localClusterProvider) clusterName() string {
+	if p.settings.ProjectName == "" {
+		return "dimo"
+	}
+	return p.settings.ProjectName
+}
+
+func (p *localClusterProvider) registryName() string {
+	return p.clusterName() + "-registry"
+}
+
+func (p *localClusterProvider) CreateNetwork(ctx *pulumi.Context) (err error) {
+	if p.settings.CloudProvider != "local" {
+		return fmt.Errorf("deployment type %s requires cloud-provider local", p.tool)
+	}
+
+	// k3d creates and wires up its own registry, kind needs one running beside it
+	if !p.settings.Local.Registry || p.tool != "kind" {
+		return nil
+	}
+
+	p.registry, err = local.NewCommand(ctx, "local-registry", &local.CommandArgs{
+		Create: pulumi.Sprintf("docker run -d --restart=always -p 127.0.0.1:%d:5000 --network bridge --name %s registry:2", p.settings.Local.RegistryPort, p.registryName()),
+		Delete: pulumi.Sprintf("docker rm -f %s", p.registryName()),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *localClusterProvider) CreateCluster(ctx *pulumi.Context) (err error) {
+	var create, remove string
+	switch p.tool {
+	case "kind":
+		create = p.kindCreateCommand()
+		remove = fmt.Sprintf("kind delete cluster --name %s", p.clusterName())
+	case "k3d":
+		create = p.k3dCreateCommand()
+		remove = fmt.Sprintf("k3d cluster delete %s", p.clusterName())
+	}
+
+	var dependsOn []pulumi.Resource
+	if p.registry != nil {
+		dependsOn = append(dependsOn, p.registry)
+	}
+
+	p.cluster, err = local.NewCommand(ctx, "local-cluster", &local.CommandArgs{
+		Create: pulumi.String(create),
+		Delete: pulumi.String(remove),
+	}, pulumi.DependsOn(dependsOn))
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("clusterName", pulumi.String(p.clusterName()))
+	if p.settings.Local.Registry {
+		ctx.Export("localRegistry", pulumi.Sprintf("localhost:%d", p.settings.Local.RegistryPort))
+	}
+
+	return nil
+}
+
+// kindCreateCommand creates the kind cluster with the ingress ports mapped onto the control plane node.
+// The ingress-ready label is what InstallNginxIngress pins the controller to on kind.
+func (p *localClusterProvider) kindCreateCommand() string {
+	kindConfig := fmt.Sprintf(`kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+nodes:
+- role: control-plane
+  kubeadmConfigPatches:
+  - |
+    kind: InitConfiguration
+    nodeRegistration:
+      kubeletExtraArgs:
+        node-labels: "ingress-ready=true"
+  extraPortMappings:
+  - containerPort: 80
+    hostPort: %d
+    protocol: TCP
+  - containerPort: 443
+    hostPort: %d
+    protocol: TCP`, p.settings.Local.HttpPort, p.settings.Local.HttpsPort)
+
+	if p.settings.Local.Registry {
+		kindConfig += fmt.Sprintf(`
+containerdConfigPatches:
+- |-
+  [plugins."io.containerd.grpc.v1.cri".registry.mirrors."localhost:%d"]
+    endpoint = ["http://%s:5000"]`, p.settings.Local.RegistryPort, p.registryName())
+	}
+
+	commands := []string{
+		fmt.Sprintf("kind create cluster --name %s --config - <<'EOF'\n%s\nEOF", p.clusterName(), kindConfig),
+	}
+	if p.settings.Local.Registry {
+		// The registry has to share the kind docker network for the mirror endpoint to resolve
+		commands = append(commands, fmt.Sprintf("(docker network connect kind %s || true)", p.registryName()))
+	}
+
+	return strings.Join(commands, " && ")
+}
+
+// k3dCreateCommand creates the k3d cluster with the ingress ports mapped through the k3d load balancer.
+// Traefik is disabled since the nginx ingress from the dependencies takes its place.
+func (p *localClusterProvider) k3dCreateCommand() string {
+	args := []string{
+		"k3d cluster create", p.clusterName(),
+		fmt.Sprintf("-p %d:80@loadbalancer", p.settings.Local.HttpPort),
+		fmt.Sprintf("-p %d:443@loadbalancer", p.settings.Local.HttpsPort),
+		"--k3s-arg --disable=traefik@server:0",
+		"--kubeconfig-update-default=false",
+	}
+	if p.settings.Local.Registry {
+		args = append(args, fmt.Sprintf("--registry-create %s:0.0.0.0:%d", p.registryName(), p.settings.Local.RegistryPort))
+	}
+
+	return strings.Join(args, " ")
+}
+
+func (p *localClusterProvider) CreateNodePools(ctx *pulumi.Context) error {
+	return nil
+}
+
+func (p *localClusterProvider) GetKubeProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	var getKubeConfigCmd string
+	switch p.tool {
+	case "kind":
+		getKubeConfigCmd = fmt.Sprintf("kind get kubeconfig --name %s", p.clusterName())
+	case "k3d":
+		getKubeConfigCmd = fmt.Sprintf("k3d kubeconfig get %s", p.clusterName())
+	}
+
+	getKubeConfig, err := local.NewCommand(ctx, "getkubeconfig", &local.CommandArgs{
+		Create: pulumi.String(getKubeConfigCmd),
+	}, pulumi.DependsOn([]pulumi.Resource{p.cluster}))
+	if err != nil {
+		return nil, err
+	}
+
+	kubeConfig := pulumi.ToSecret(getKubeConfig.Stdout).(pulumi.StringOutput)
+
+	kubeProvider, err := kubernetes.NewProvider(ctx, "Localk8sProvider", &kubernetes.ProviderArgs{
+		Kubeconfig: kubeConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kubeProvider, nil
+}
@@ -9,27 +9,45 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func CreateNetwork(ctx *pulumi.Context, cloudProvider string, region string, projectName string, whitelistIp string) (*compute.Network, *compute.Subnetwork, error) {
+// NetworkResult is the provider neutral network returned by CreateNetwork.
+// Cluster providers only consume these IDs so every cloud gets the same network and firewall semantics.
+type NetworkResult struct {
+	// NetworkId is the GCP network, AWS VPC or Azure VNet ID
+	NetworkId pulumi.StringOutput
+	// PublicSubnetIds are the subnets that hand out public IPs (GCP uses one regional subnetwork for both)
+	PublicSubnetIds pulumi.StringArray
+	// PrivateSubnetIds are the subnets that egress through NAT
+	PrivateSubnetIds pulumi.StringArray
+	// FirewallIds are the GCP firewalls, AWS security groups or Azure NSGs that apply the firewall rule sets
+	FirewallIds pulumi.StringArray
+	// InstanceTags are the network tags GCP firewalls target, AWS attaches FirewallIds directly instead
+	InstanceTags pulumi.StringArray
+	// ResourceGroupName is the Azure resource group everything is created in, unset on other clouds
+	ResourceGroupName pulumi.StringOutput
+}
+
+func CreateNetwork(ctx *pulumi.Context, cloudProvider string, region string, projectName string, ruleSets []utils.FirewallRuleSet) (*NetworkResult, error) {
 	switch cloudProvider {
 	case "aws":
-		return CreateAWSNetwork(ctx, region, projectName, whitelistIp)
+		return CreateAWSNetwork(ctx, region, projectName, ruleSets)
 	case "gcp":
-		return CreateGCPNetwork(ctx, region, projectName, whitelistIp)
+		return CreateGCPNetwork(ctx, region, projectName, ruleSets)
+	case "azure":
+		return CreateAzureNetwork(ctx, region, projectName, ruleSets)
 	default:
-		return nil, nil, fmt.Errorf("cloud provider %s not supported", cloudProvider)
+		return nil, fmt.Errorf("cloud provider %s not supported", cloudProvider)
 	}
 }
 
-func CreateGCPNetwork(ctx *pulumi.Context, region string, projectName string, whitelistIp string) (*compute.Network, *compute.Subnetwork, error) {
+func CreateGCPNetwork(ctx *pulumi.Context, region string, projectName string, ruleSets []utils.FirewallRuleSet) (*NetworkResult, error) {
 	networkName := fmt.Sprintf("%s-network", projectName)
 	subnetworkName := fmt.Sprintf("%s-subnetwork", projectName)
-	firewallName := fmt.Sprintf("%s-firewall", projectName)
 
 	network, err := compute.NewNetwork(ctx, networkName, &compute.NetworkArgs{
 		AutoCreateSubnetworks: pulumi.Bool(false),
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Create a GCP Subnetwork
@@ -40,36 +58,58 @@ func CreateGCPNetwork(ctx *pulumi.Context, region string, projectName string, wh
 		//Region:      pulumi.String(region),
 	}, pulumi.DependsOn([]pulumi.Resource{network}))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	// Create firewall rule to allow appropriate traffic in
-	firewall, err := compute.NewFirewall(ctx, firewallName, &compute.FirewallArgs{
-		Network: network.Name,
-		Allows: compute.FirewallAllowArray{
-			&compute.FirewallAllowArgs{
-				Protocol: pulumi.String("tcp"),
-				Ports:    utils.ToPulumiStringArray(firewallOpenPorts),
-			},
-		},
-		Direction: pulumi.String("INGRESS"),
-		SourceRanges: pulumi.StringArray{
-			pulumi.String(whitelistIp),
-		},
-		TargetTags: pulumi.StringArray{
-			pulumi.String(instanceTag),
-		},
-	}, pulumi.DependsOn([]pulumi.Resource{subnetwork}))
+	// Create a firewall per rule set to allow appropriate traffic in
+	firewallIds, firewallNames, err := createGCPFirewalls(ctx, projectName, network, ruleSets, pulumi.DependsOn([]pulumi.Resource{subnetwork}))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	ctx.Export("subnetworkName", subnetwork.Name)
-	ctx.Export("firewallName", firewall.Name)
+	ctx.Export("firewallNames", firewallNames)
+
+	return &NetworkResult{
+		NetworkId:        network.ID().ToStringOutput(),
+		PublicSubnetIds:  pulumi.StringArray{subnetwork.ID()},
+		PrivateSubnetIds: pulumi.StringArray{subnetwork.ID()},
+		FirewallIds:      firewallIds,
+		InstanceTags:     pulumi.StringArray{instanceTag},
+	}, nil
+}
+
+// CreateGCPCloudNAT gives instances without external IPs (private GKE nodes) egress through a Cloud Router and Cloud NAT
+func CreateGCPCloudNAT(ctx *pulumi.Context, region string, projectName string, network *NetworkResult) error {
+	router, err := compute.NewRouter(ctx, fmt.Sprintf("%s-router", projectName), &compute.RouterArgs{
+		Network: network.NetworkId,
+		Region:  pulumi.String(region),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = compute.NewRouterNat(ctx, fmt.Sprintf("%s-nat", projectName), &compute.RouterNatArgs{
+		Router:                        router.Name,
+		Region:                        router.Region,
+		NatIpAllocateOption:           pulumi.String("AUTO_ONLY"),
+		SourceSubnetworkIpRangesToNat: pulumi.String("ALL_SUBNETWORKS_ALL_IP_RANGES"),
+		LogConfig: &compute.RouterNatLogConfigArgs{
+			Enable: pulumi.Bool(true),
+			Filter: pulumi.String("ERRORS_ONLY"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	return network, subnetwork, nil
+func CreateAWSNetwork(ctx *pulumi.Context, region string, projectName string, ruleSets []utils.FirewallRuleSet) (*NetworkResult, error) {
+	return buildAWSNetworking(ctx, projectName, ruleSets)
 }
 
-func CreateAWSNetwork(ctx *pulumi.Context, region string, projectName string, whitelistIp string) (*compute.Network, *compute.Subnetwork, error) {
-	return nil, nil, nil
+func CreateAzureNetwork(ctx *pulumi.Context, region string, projectName string, ruleSets []utils.FirewallRuleSet) (*NetworkResult, error) {
+	return buildAzureNetworking(ctx, region, projectName, ruleSets)
 }
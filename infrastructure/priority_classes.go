@@ -0,0 +1,51 @@
+package infrastructure
+
+import (
+	"github.com/dimo/dimo-node/utils"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	schedulingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/scheduling/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type priorityTier struct {
+	name             string
+	value            int
+	preemptionPolicy string
+	description      string
+}
+
+// The tiers sit well below system-cluster-critical, so the cluster's own add-ons still win
+var priorityTiers = []priorityTier{
+	{utils.PriorityCritical, 1000000, "PreemptLowerPriority", "DIMO platform services the rest of the node depends on"},
+	{utils.PriorityCore, 100000, "PreemptLowerPriority", "DIMO applications, their database and monitoring"},
+	{utils.PriorityBatch, 1000, "Never", "DIMO jobs, they wait for room instead of preempting"},
+}
+
+// high-priority is what every deployment type created before the tiers. Nothing here uses it any more, it is
+// kept unchanged so workloads outside this repo that still name it aren't rejected. Same value as dimo-core.
+var legacyPriorityClass = priorityTier{"high-priority", 100000, "PreemptLowerPriority", "This is a high priority class"}
+
+// CreatePriorityTiers creates the priority classes the dependencies and applications are scheduled with.
+// Every deployment type gets the same tiers, so preemption works the same wherever the node runs.
+// The classes are returned so the charts and releases using them can depend on them.
+func CreatePriorityTiers(ctx *pulumi.Context, kubeProvider *kubernetes.Provider) ([]pulumi.Resource, error) {
+	var classes []pulumi.Resource
+	for _, tier := range append(priorityTiers, legacyPriorityClass) {
+		class, err := schedulingv1.NewPriorityClass(ctx, tier.name, &schedulingv1.PriorityClassArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(tier.name),
+			},
+			Value:            pulumi.Int(tier.value),
+			PreemptionPolicy: pulumi.String(tier.preemptionPolicy),
+			Description:      pulumi.String(tier.description),
+			GlobalDefault:    pulumi.Bool(false),
+		}, pulumi.Provider(kubeProvider))
+		if err != nil {
+			return nil, err
+		}
+		classes = append(classes, class)
+	}
+
+	return classes, nil
+}
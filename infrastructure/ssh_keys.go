@@ -1,20 +1,43 @@
 package infrastructure
 
 import (
-	"os"
+	"strings"
+
+	"github.com/pulumi/pulumi-tls/sdk/v4/go/tls"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func ReadSSHKeysFromDisk(pubKeyPath string, privKeyPath string) (string, string, error) {
-	pubKey, err := os.ReadFile(pubKeyPath)
-	pubKeyString := string(pubKey)
-	if err != nil {
-		return "", "", err
+// SSHKeyPair is the key pulumi connects to the k3s hosts with, the private half only ever lives in state as a secret
+type SSHKeyPair struct {
+	PublicKey  pulumi.StringOutput
+	PrivateKey pulumi.StringOutput
+}
+
+// CreateSSHKeyPair generates the k3s host key. Changing version renames the resource, so pulumi
+// creates a fresh key and deletes the old one, which is how the key is rotated.
+func CreateSSHKeyPair(ctx *pulumi.Context, version string) (*SSHKeyPair, error) {
+	name := "ssh-key"
+	if version != "" {
+		name = name + "-" + version
 	}
-	privKey, err := os.ReadFile(privKeyPath)
-	privKeyString := string(privKey)
+
+	key, err := tls.NewPrivateKey(ctx, name, &tls.PrivateKeyArgs{
+		Algorithm: pulumi.String("ED25519"),
+	})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return pubKeyString, privKeyString, nil
+	// The OpenSSH public key comes with a trailing newline that breaks the metadata and cloud-init formats
+	publicKey := key.PublicKeyOpenssh.ApplyT(func(publicKey string) string {
+		return strings.TrimSpace(publicKey)
+	}).(pulumi.StringOutput)
+
+	ctx.Export("sshPublicKey", publicKey)
+	ctx.Export("sshPrivateKey", pulumi.ToSecret(key.PrivateKeyOpenssh))
+
+	return &SSHKeyPair{
+		PublicKey:  publicKey,
+		PrivateKey: key.PrivateKeyOpenssh,
+	}, nil
 }
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var dnsDomainPattern = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?\.)+[a-z]{2,}$`)
+var cloudDNSZonePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+var route53ZonePattern = regexp.MustCompile(`^Z[A-Z0-9]+$`)
+
+// Host is the public hostname an app's ingress is served at, <app>.<dns-domain>
+func (c *NodeConfig) Host(app string) string {
+	return app + "." + c.DNSDomain
+}
+
+// validateDNS checks the domain and the zone external-dns manages records in
+func (c *NodeConfig) validateDNS() (problems []string) {
+	if !dnsDomainPattern.MatchString(c.DNSDomain) {
+		problems = append(problems, fmt.Sprintf("dns-domain %s is not a valid domain (ex: dimo.zone)", c.DNSDomain))
+	}
+	if !dnsDomainPattern.MatchString(c.GrafanaHost) {
+		problems = append(problems, fmt.Sprintf("grafana-host %s is not a valid hostname (ex: monitoring.dimo.zone)", c.GrafanaHost))
+	}
+
+	if !c.ExternalDNS {
+		if c.DNSZone != "" {
+			problems = append(problems, "dns-zone is only used when external-dns is enabled")
+		}
+		return problems
+	}
+
+	switch c.CloudProvider {
+	case "gcp":
+		if c.GCPProject == "" {
+			problems = append(problems, "gcp-project is required for external-dns on gcp")
+		}
+		if !c.GCPWorkloadIdentity && c.GCPCredentials == nil {
+			problems = append(problems, "gcp-credentials is required for external-dns when gcp-workload-identity is disabled")
+		}
+		if c.DNSZone != "" && !cloudDNSZonePattern.MatchString(c.DNSZone) {
+			problems = append(problems, fmt.Sprintf("dns-zone %s must be the name of a Cloud DNS managed zone (ex: dimo-zone)", c.DNSZone))
+		}
+	case "aws":
+		// IRSA needs the cluster's OIDC provider, which only the eks deployment type creates
+		if c.DeploymentType != "eks" {
+			problems = append(problems, "external-dns on aws is only supported with deployment-type eks")
+		}
+		if c.DNSZone != "" && !route53ZonePattern.MatchString(c.DNSZone) {
+			problems = append(problems, fmt.Sprintf("dns-zone %s must be a Route53 hosted zone id (ex: Z0123456789ABCDEFGHIJ)", c.DNSZone))
+		}
+	default:
+		problems = append(problems, "external-dns is only supported on gcp and aws")
+	}
+
+	return problems
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ExternalSecretArgs describes a Kubernetes secret synced from cluster-secret-store
+type ExternalSecretArgs struct {
+	Name       string // Name of the ExternalSecret, defaults to SecretName
+	Namespace  string
+	SecretName string            // Name of the secret it writes
+	Data       map[string]string // Key in the Kubernetes secret to remote key in the backend
+	DependsOn  []pulumi.Resource
+}
+
+// ExternalSecret syncs a Kubernetes secret from the backend and signals once it is there.
+// Helm releases that read the secret depend on Ready, so their pods don't crash-loop on a first install.
+type ExternalSecret struct {
+	pulumi.ResourceState
+
+	// Ready resolves once the target secret exists with every key in Data
+	Ready pulumi.ResourceOutput
+}
+
+// NewExternalSecret creates the ExternalSecret and waits for it to report SecretSynced.
+// Existing ExternalSecrets keep their URN through the NoParent alias, so they aren't recreated.
+func NewExternalSecret(ctx *pulumi.Context, name string, kubeProvider *kubernetes.Provider, args ExternalSecretArgs, opts ...pulumi.ResourceOption) (*ExternalSecret, error) {
+	component := &ExternalSecret{}
+	err := ctx.RegisterComponentResource("dimo-node:secrets:ExternalSecret", name, component, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Name == "" {
+		args.Name = args.SecretName
+	}
+
+	// Sorted so the spec doesn't show a diff on every run
+	keys := make([]string, 0, len(args.Data))
+	for key := range args.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := []map[string]interface{}{}
+	for _, key := range keys {
+		data = append(data, map[string]interface{}{
+			"secretKey": key,
+			"remoteRef": map[string]interface{}{
+				"key": args.Data[key],
+			},
+		})
+	}
+
+	externalSecret, err := apiextensions.NewCustomResource(ctx, name, &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("external-secrets.io/v1beta1"),
+		Kind:       pulumi.String("ExternalSecret"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(args.Name),
+			Namespace: pulumi.String(args.Namespace),
+			Annotations: pulumi.StringMap{
+				// external-secrets sets Ready with reason SecretSynced once the target secret is written
+				"pulumi.com/waitFor": pulumi.String("condition=Ready"),
+			},
+		},
+		OtherFields: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"refreshInterval": "1h",
+				"secretStoreRef": map[string]interface{}{
+					"name": "cluster-secret-store",
+					"kind": "ClusterSecretStore",
+				},
+				"target": map[string]interface{}{
+					"name":           args.SecretName,
+					"creationPolicy": "Owner",
+				},
+				"data": data,
+			},
+		},
+	}, pulumi.Provider(kubeProvider),
+		pulumi.Parent(component),
+		pulumi.DependsOn(args.DependsOn),
+		pulumi.Aliases([]pulumi.Alias{{NoParent: pulumi.Bool(true)}}))
+	if err != nil {
+		return nil, err
+	}
+
+	// The id comes from the ExternalSecret, so a first preview doesn't try to read a secret that isn't there yet
+	secretID := externalSecret.Metadata.Namespace().ApplyT(func(namespace *string) pulumi.ID {
+		return pulumi.ID(fmt.Sprintf("%s/%s", *namespace, args.SecretName))
+	}).(pulumi.IDOutput)
+
+	secret, err := corev1.GetSecret(ctx, name+"-target", secretID, nil,
+		pulumi.Provider(kubeProvider),
+		pulumi.Parent(component),
+		pulumi.DependsOn([]pulumi.Resource{externalSecret}))
+	if err != nil {
+		return nil, err
+	}
+
+	component.Ready = secret.Data.ApplyT(func(secretData map[string]string) (pulumi.Resource, error) {
+		for _, key := range keys {
+			if _, set := secretData[key]; !set {
+				return nil, fmt.Errorf("secret %s/%s is missing key %s", args.Namespace, args.SecretName, key)
+			}
+		}
+		return secret, nil
+	}).(pulumi.ResourceOutput)
+
+	err = ctx.RegisterResourceOutputs(component, pulumi.Map{})
+	if err != nil {
+		return nil, err
+	}
+
+	return component, nil
+}
+
+// DependsOnSecrets makes a resource wait until every secret is synced with its keys
+func DependsOnSecrets(secrets ...*ExternalSecret) pulumi.ResourceOption {
+	ready := pulumi.ResourceArray{}
+	for _, secret := range secrets {
+		ready = append(ready, secret.Ready)
+	}
+	return pulumi.DependsOnInputs(ready)
+}
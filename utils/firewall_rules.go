@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var firewallProtocols = []string{"tcp", "udp", "icmp", "all"}
+
+// FirewallRuleSet is one entry of the firewall-rules config list, opening ports to a list of source CIDRs.
+// Each set becomes its own GCP firewall, ingress on the AWS whitelist security group and a rule on the Azure NSG.
+type FirewallRuleSet struct {
+	Name             string   `json:"name"`
+	Protocol         string   `json:"protocol"`         // tcp, udp, icmp or all, defaults to tcp
+	Ports            []string `json:"ports"`            // Single ports or ranges (ex: 30000-32767), ignored for icmp and all
+	SourceCidrs      []string `json:"sourceCidrs"`      // Defaults to whitelist-ip
+	TargetTags       []string `json:"targetTags"`       // GCP network tags, defaults to the tag every node VM carries
+	SecurityGroupIds []string `json:"securityGroupIds"` // Existing AWS security groups to add the rules to instead of the whitelist group
+}
+
+// defaultFirewallRuleSets open SSH and the Kubernetes API to whitelist-ip, used when firewall-rules is not set.
+// The Postgres operator UI (31544) used to be opened too, it now has to be asked for per stack.
+var defaultFirewallRuleSets = []FirewallRuleSet{
+	{Name: "ssh", Ports: []string{"22"}},
+	{Name: "kube-api", Ports: []string{"6443"}},
+}
+
+// HasPorts reports whether the protocol is one that takes ports
+func (r FirewallRuleSet) HasPorts() bool {
+	return r.Protocol == "tcp" || r.Protocol == "udp"
+}
+
+// ParsePortRange splits a firewall-rules port (22 or 30000-32767) into its first and last port
+func ParsePortRange(port string) (int, int, error) {
+	fromPort, toPort, isRange := strings.Cut(port, "-")
+	if !isRange {
+		toPort = fromPort
+	}
+
+	from, err := strconv.Atoi(fromPort)
+	if err != nil {
+		return 0, 0, fmt.Errorf("port %s is not a number or range", port)
+	}
+	to, err := strconv.Atoi(toPort)
+	if err != nil {
+		return 0, 0, fmt.Errorf("port %s is not a number or range", port)
+	}
+	if from < 1 || to > 65535 || from > to {
+		return 0, 0, fmt.Errorf("port %s must be between 1 and 65535 with the lower port first", port)
+	}
+
+	return from, to, nil
+}
+
+// withDefaults fills in the protocol, and the sources from whitelist-ip when the set has none
+func (r FirewallRuleSet) withDefaults(whitelistIp string) FirewallRuleSet {
+	if r.Protocol == "" {
+		r.Protocol = "tcp"
+	}
+	if len(r.SourceCidrs) == 0 && whitelistIp != "" {
+		r.SourceCidrs = []string{whitelistIp}
+	}
+	return r
+}
+
+// validate checks one rule set, key is the config key it came from for the error messages
+func (r FirewallRuleSet) validate(key string) (problems []string) {
+	if !nodePoolNamePattern.MatchString(r.Name) {
+		problems = append(problems, fmt.Sprintf("%s name %q must be lowercase letters, numbers and dashes", key, r.Name))
+	}
+	if !slices.Contains(firewallProtocols, r.Protocol) {
+		problems = append(problems, fmt.Sprintf("%s %s protocol %s must be one of %s", key, r.Name, r.Protocol, strings.Join(firewallProtocols, ", ")))
+	}
+	if r.HasPorts() && len(r.Ports) == 0 {
+		problems = append(problems, fmt.Sprintf("%s %s needs at least one port for %s", key, r.Name, r.Protocol))
+	}
+	if r.HasPorts() {
+		for _, port := range r.Ports {
+			if _, _, err := ParsePortRange(port); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s %v", key, r.Name, err))
+			}
+		}
+	}
+
+	// An empty source list opens the ports to the internet on GCP, so it always has to be explicit
+	if len(r.SourceCidrs) == 0 {
+		problems = append(problems, fmt.Sprintf("%s %s needs sourceCidrs or whitelist-ip to be set", key, r.Name))
+	}
+	for _, cidr := range r.SourceCidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s source %s is not a valid CIDR", key, r.Name, cidr))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GKECredentialsEnv carries the base64 gcp-credentials key from the kubeconfig to the credential helper
+const GKECredentialsEnv = "GKE_CREDENTIALS_B64"
+
+// GKEExecCredential mints a GKE access token and wraps it in the ExecCredential kubeconfig exec plugins return.
+// credentialsJSON is a service account key, application default credentials are used when it is empty.
+func GKEExecCredential(credentialsJSON string) ([]byte, error) {
+	var tokenSource oauth2.TokenSource
+	if credentialsJSON != "" {
+		credentials, err := google.CredentialsFromJSON(context.Background(), []byte(credentialsJSON), cloudPlatformScope)
+		if err != nil {
+			return nil, err
+		}
+		tokenSource = credentials.TokenSource
+	} else {
+		var err error
+		tokenSource, err = google.DefaultTokenSource(context.Background(), cloudPlatformScope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := tokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	// The expiry lets the client cache the token and only call the helper again once it runs out
+	expiry := metav1.NewTime(token.Expiry)
+	return json.Marshal(clientauthv1beta1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthv1beta1.ExecCredentialStatus{
+			Token:               token.AccessToken,
+			ExpirationTimestamp: &expiry,
+		},
+	})
+}
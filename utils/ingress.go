@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+)
+
+var ingressLoadBalancers = []string{"external", "internal"}
+
+// validateIngress checks the load balancer ingress-nginx is exposed through
+func (c *NodeConfig) validateIngress() (problems []string) {
+	if !slices.Contains(ingressLoadBalancers, c.IngressLoadBalancer) {
+		problems = append(problems, fmt.Sprintf("ingress-load-balancer %s not supported (available: external, internal)", c.IngressLoadBalancer))
+	}
+	// Only the managed clusters get a load balancer with annotations we know how to set
+	if c.IngressLoadBalancer == "internal" && !slices.Contains([]string{"gke", "gke-autopilot", "eks", "aks"}, c.DeploymentType) {
+		problems = append(problems, "ingress-load-balancer internal is only supported on gke, gke-autopilot, eks and aks")
+	}
+
+	if c.IngressStaticIP {
+		if !slices.Contains([]string{"gke", "gke-autopilot", "eks"}, c.DeploymentType) {
+			problems = append(problems, "ingress-static-ip is only supported on gke, gke-autopilot and eks")
+		}
+		// Elastic IPs are public, an internal NLB gets its private addresses from the subnets
+		if c.DeploymentType == "eks" && c.IngressLoadBalancer == "internal" {
+			problems = append(problems, "ingress-static-ip is not supported with an internal load balancer on eks")
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var k3sVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+\+k3s\d+$`)
+var packageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]*$`)
+var sysctlKeyPattern = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_-]+)+$`)
+
+// K3sHostConfig is the k3s-host config object, shaping the user-data every k3s VM boots with
+type K3sHostConfig struct {
+	Version            string            `json:"version"`     // k3s release to install (ex: v1.30.4+k3s1), the stable channel when unset
+	ServerFlags        []string          `json:"serverFlags"` // Extra flags for k3s servers (ex: --disable=traefik)
+	AgentFlags         []string          `json:"agentFlags"`  // Extra flags for k3s agents
+	Packages           []string          `json:"packages"`    // apt packages installed at boot, defaults to jq
+	Sysctl             map[string]string `json:"sysctl"`
+	UnattendedUpgrades bool              `json:"unattendedUpgrades"` // Defaults to true, security updates only and never reboots
+	UpgradeController  bool              `json:"upgradeController"`  // Upgrade running nodes to version with the system-upgrade-controller
+}
+
+// defaultK3sHostConfig turns on security updates, the fields read from config are layered on top
+var defaultK3sHostConfig = K3sHostConfig{
+	UnattendedUpgrades: true,
+}
+
+// withDefaults installs jq like the VM startup script always meant to, an explicit empty list installs nothing
+func (h K3sHostConfig) withDefaults() K3sHostConfig {
+	if h.Packages == nil {
+		h.Packages = []string{"jq"}
+	}
+	return h
+}
+
+// validate checks the values that end up in the rendered shell script, key is the config key for the error messages
+func (h K3sHostConfig) validate(key string) (problems []string) {
+	if h.Version != "" && !k3sVersionPattern.MatchString(h.Version) {
+		problems = append(problems, fmt.Sprintf("%s version %s must be a k3s release (ex: v1.30.4+k3s1)", key, h.Version))
+	}
+	if h.UpgradeController && h.Version == "" {
+		problems = append(problems, fmt.Sprintf("%s upgradeController needs a pinned version to upgrade to", key))
+	}
+	for _, pkg := range h.Packages {
+		if !packageNamePattern.MatchString(pkg) {
+			problems = append(problems, fmt.Sprintf("%s package %q is not a valid apt package name", key, pkg))
+		}
+	}
+	for name, value := range h.Sysctl {
+		if !sysctlKeyPattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("%s sysctl %q is not a valid sysctl key (ex: fs.inotify.max_user_instances)", key, name))
+		}
+		if value == "" || strings.ContainsAny(value, "\n'\"\\$`") {
+			problems = append(problems, fmt.Sprintf("%s sysctl %s value %q cannot be empty or contain quotes, newlines or shell characters", key, name, value))
+		}
+	}
+	for _, flag := range append(append([]string{}, h.ServerFlags...), h.AgentFlags...) {
+		if !strings.HasPrefix(flag, "--") {
+			problems = append(problems, fmt.Sprintf("%s flag %q must start with --", key, flag))
+		}
+	}
+
+	return problems
+}
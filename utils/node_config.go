@@ -0,0 +1,548 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+var cloudProviders = []string{"gcp", "aws", "azure", "local"}
+
+// The cloud providers each deployment type can build on, existing clusters can live anywhere
+var deploymentTypeClouds = map[string][]string{
+	"gke":           {"gcp"},
+	"gke-autopilot": {"gcp"},
+	"eks":           {"aws"},
+	"aks":           {"azure"},
+	"k3s":           {"gcp", "aws"},
+	"kind":          {"local"},
+	"k3d":           {"local"},
+}
+var sshKeyVersionPattern = regexp.MustCompile(`^[a-z0-9][-a-z0-9]*$`)
+
+// NodeConfig is the dimo-node stack configuration, loaded once in main and handed to every package.
+// Defaults are filled in by LoadNodeConfig, so code reading it never needs to check for empty values.
+type NodeConfig struct {
+	CloudProvider   string
+	DeploymentType  string
+	ProjectName     string // Defaults to the stack name
+	Region          string // Defaults to gcp:region, aws:region or azure-native:location
+	Location        string // Defaults to gcp:zone, otherwise the region
+	Locations       []string
+	WhitelistIp     string            // Default source for the firewall rule sets
+	FirewallRules   []FirewallRuleSet // Defaults to SSH and the Kubernetes API from whitelist-ip
+	CreateNodePools bool
+	Environment     string // Defaults to dev
+
+	// Ingress
+	IngressLoadBalancer string // external or internal, defaults to external
+	IngressStaticIP     bool   // Reserve the load balancer address, defaults to true on gke and gke-autopilot
+
+	// DNS
+	DNSDomain   string // Apps are served at <app>.<dns-domain>, defaults to dimo.zone
+	ExternalDNS bool   // Create the zone and let external-dns keep the ingress records in it
+	DNSZone     string // Existing Cloud DNS zone name or Route53 hosted zone id to adopt instead of creating one
+	GrafanaHost string // Defaults to monitoring.driveomid.xyz, where Grafana has always been served
+
+	// Upgrades
+	UpgradeSettings   *UpgradeSettings   // Surge settings for GKE node pools and EKS node groups, the cloud's defaults when unset
+	MaintenanceWindow *MaintenanceWindow // GKE only
+
+	// Secrets
+	SecretsBackend      string // gcpsm, aws, vault or fake, defaults to gcpsm on gcp, aws on eks and fake elsewhere
+	GCPProject          string // Defaults to gcp:project
+	ClusterName         string
+	GCPWorkloadIdentity bool // Defaults to true on gke and gke-autopilot
+	GCPCredentials      *pulumi.StringOutput
+	AWSSecretsRoleArn   string            // Existing IAM role for external-secrets to assume instead of the one created on eks
+	Vault               VaultSettings     // vault backend only
+	FakeSecrets         map[string]string // Remote key to value, served by the fake backend
+	Secrets             []SecretSpec      // Backend secrets pulumi creates, and the Kubernetes secrets they are synced into
+	PasswordConfigs     map[string]PasswordConfig
+
+	// gke
+	GKEVersion            string      // Control plane and node version, latest when unset
+	GKEDefaultPool        GKENodePool // Shape of the pool GKE creates with the cluster, 3 nodes by default
+	GKERemoveDefaultPool  bool
+	GKENodePools          []GKENodePool // Built when create-node-pools is set, defaults to a single small pool
+	GKEClusterAutoscaling GKEClusterAutoscaling
+	GKEPrivateCluster     bool     // Private nodes behind Cloud NAT
+	GKEPrivateEndpoint    bool     // Also hide the control plane, pulumi has to run from inside the VPC
+	GKEMasterIpv4Cidr     string   // Defaults to 172.16.0.0/28
+	MasterAuthorizedCidrs []string // CIDRs allowed to reach the control plane, open to all when empty
+
+	// eks
+	EKSVersion          string         // Kubernetes version the cluster is pinned to, AWS picks the latest when unset
+	EKSDefaultNodeGroup EKSNodeGroup   // Group created with the cluster
+	EKSNodeGroups       []EKSNodeGroup // Built when create-node-pools is set, defaults to small and medium groups
+
+	// aks
+	AKSSystemPool AKSNodePool // Pool created with the cluster, runs the AKS system pods
+	AKSUserPool   AKSNodePool // Built when create-node-pools is set
+
+	// k3s
+	K3sServers    int // Defaults to 1
+	K3sAgents     int
+	K3sHA         bool
+	K3sHost       K3sHostConfig // User-data the VMs boot with, version and flags k3s is installed with
+	SSHKeyVersion string        // Bump to rotate the generated host SSH key
+
+	// existing
+	Kubeconfig     *pulumi.StringOutput
+	KubeconfigPath string
+	KubeContext    string
+
+	// kind / k3d
+	LocalRegistry     bool
+	LocalRegistryPort int // Defaults to 5001
+	LocalHttpPort     int // Defaults to 80
+	LocalHttpsPort    int // Defaults to 443
+}
+
+// LoadNodeConfig reads the stack configuration, fills in defaults and validates it.
+// deploymentTypes are the deployment types the caller can build, every problem found is returned in one error.
+func LoadNodeConfig(ctx *pulumi.Context, deploymentTypes []string) (*NodeConfig, error) {
+	conf := config.New(ctx, "")
+	var problems []string
+
+	nodeConfig := &NodeConfig{
+		CloudProvider:         conf.Get("cloud-provider"),
+		DeploymentType:        conf.Get("deployment-type"),
+		ProjectName:           conf.Get("project-name"),
+		Region:                conf.Get("region"),
+		Location:              conf.Get("location"),
+		WhitelistIp:           conf.Get("whitelist-ip"),
+		Environment:           conf.Get("environment"),
+		IngressLoadBalancer:   conf.Get("ingress-load-balancer"),
+		DNSDomain:             conf.Get("dns-domain"),
+		GrafanaHost:           conf.Get("grafana-host"),
+		DNSZone:               conf.Get("dns-zone"),
+		SecretsBackend:        conf.Get("secrets-backend"),
+		GCPProject:            conf.Get("gcp-project"),
+		AWSSecretsRoleArn:     conf.Get("aws-secrets-role-arn"),
+		ClusterName:           conf.Get("cluster-name"),
+		KubeconfigPath:        conf.Get("kubeconfig-path"),
+		KubeContext:           conf.Get("kube-context"),
+		GKEDefaultPool:        GKENodePool{Name: "default-pool"},
+		GKEClusterAutoscaling: defaultGKEClusterAutoscaling,
+		GKEMasterIpv4Cidr:     conf.Get("gke-master-ipv4-cidr"),
+		GKEVersion:            conf.Get("gke-version"),
+		EKSVersion:            conf.Get("eks-version"),
+		EKSDefaultNodeGroup:   defaultEKSNodeGroup,
+		AKSSystemPool:         defaultAKSSystemPool,
+		AKSUserPool:           defaultAKSUserPool,
+		K3sServers:            1,
+		K3sHost:               defaultK3sHostConfig,
+		SSHKeyVersion:         conf.Get("ssh-key-version"),
+		LocalRegistryPort:     5001,
+		LocalHttpPort:         80,
+		LocalHttpsPort:        443,
+	}
+
+	// Typed values are read with Try* so a malformed value is reported instead of silently zeroed
+	readBool := func(key string, target *bool) {
+		if conf.Get(key) == "" {
+			return
+		}
+		value, err := conf.TryBool(key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be true or false", key))
+			return
+		}
+		*target = value
+	}
+	readInt := func(key string, target *int) {
+		if conf.Get(key) == "" {
+			return
+		}
+		value, err := conf.TryInt(key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be a number", key))
+			return
+		}
+		*target = value
+	}
+
+	// Objects and lists come back from pulumi as JSON, unset fields keep whatever target already holds
+	readObject := func(key string, target interface{}) {
+		value := conf.Get(key)
+		if value == "" {
+			return
+		}
+		if err := json.Unmarshal([]byte(value), target); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not valid: %v", key, err))
+		}
+	}
+
+	readBool("create-node-pools", &nodeConfig.CreateNodePools)
+	readBool("external-dns", &nodeConfig.ExternalDNS)
+	readObject("upgrade-settings", &nodeConfig.UpgradeSettings)
+	readObject("maintenance-window", &nodeConfig.MaintenanceWindow)
+	readObject("firewall-rules", &nodeConfig.FirewallRules)
+	readObject("gke-default-pool", &nodeConfig.GKEDefaultPool)
+	readBool("gke-remove-default-pool", &nodeConfig.GKERemoveDefaultPool)
+	readObject("gke-node-pools", &nodeConfig.GKENodePools)
+	readObject("gke-cluster-autoscaling", &nodeConfig.GKEClusterAutoscaling)
+	readBool("gke-private-cluster", &nodeConfig.GKEPrivateCluster)
+	readBool("gke-private-endpoint", &nodeConfig.GKEPrivateEndpoint)
+	readObject("master-authorized-networks", &nodeConfig.MasterAuthorizedCidrs)
+	readObject("vault", &nodeConfig.Vault)
+	readObject("fake-secrets", &nodeConfig.FakeSecrets)
+	readObject("secrets", &nodeConfig.Secrets)
+	readObject("eks-default-node-group", &nodeConfig.EKSDefaultNodeGroup)
+	readObject("eks-node-groups", &nodeConfig.EKSNodeGroups)
+	readObject("aks-system-pool", &nodeConfig.AKSSystemPool)
+	readObject("aks-user-pool", &nodeConfig.AKSUserPool)
+	readInt("k3s-servers", &nodeConfig.K3sServers)
+	readInt("k3s-agents", &nodeConfig.K3sAgents)
+	readBool("k3s-ha", &nodeConfig.K3sHA)
+	readObject("k3s-host", &nodeConfig.K3sHost)
+	readBool("local-registry", &nodeConfig.LocalRegistry)
+	readInt("local-registry-port", &nodeConfig.LocalRegistryPort)
+	readInt("local-http-port", &nodeConfig.LocalHttpPort)
+	readInt("local-https-port", &nodeConfig.LocalHttpsPort)
+
+	// Reserved addresses are only wired up where the cloud can attach them to the load balancer.
+	// eks has to opt in, an Elastic IP per availability zone plus the NAT gateway's outgrows the default quota of 5.
+	nodeConfig.IngressStaticIP = nodeConfig.DeploymentType == "gke" || nodeConfig.DeploymentType == "gke-autopilot"
+	readBool("ingress-static-ip", &nodeConfig.IngressStaticIP)
+
+	nodeConfig.GCPWorkloadIdentity = nodeConfig.DeploymentType == "gke" || nodeConfig.DeploymentType == "gke-autopilot"
+	readBool("gcp-workload-identity", &nodeConfig.GCPWorkloadIdentity)
+
+	if kubeconfig, err := conf.TrySecret("kubeconfig"); err == nil {
+		nodeConfig.Kubeconfig = &kubeconfig
+	}
+	if credentials, err := conf.TrySecret("gcp-credentials"); err == nil {
+		nodeConfig.GCPCredentials = &credentials
+	}
+
+	locations, err := parseLocations(conf.Get("locations"))
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("locations must be a list or comma separated zones: %v", err))
+	}
+	nodeConfig.Locations = locations
+
+	if passwordConfigs := conf.Get("password-configs"); passwordConfigs != "" {
+		if err := json.Unmarshal([]byte(passwordConfigs), &nodeConfig.PasswordConfigs); err != nil {
+			problems = append(problems, fmt.Sprintf("password-configs is not valid JSON: %v", err))
+		}
+	}
+
+	nodeConfig.setDefaults(ctx)
+	problems = append(problems, nodeConfig.validate(deploymentTypes)...)
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid stack configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	return nodeConfig, nil
+}
+
+func (c *NodeConfig) setDefaults(ctx *pulumi.Context) {
+	if c.ProjectName == "" {
+		c.ProjectName = ctx.Stack()
+	}
+
+	// Fall back to the cloud provider's own config so a stack doesn't have to say the same thing twice
+	if c.Region == "" {
+		switch c.CloudProvider {
+		case "gcp":
+			c.Region = config.New(ctx, "gcp").Get("region")
+		case "aws":
+			c.Region = config.New(ctx, "aws").Get("region")
+		case "azure":
+			c.Region = config.New(ctx, "azure-native").Get("location")
+		}
+	}
+	if c.Location == "" && c.CloudProvider == "gcp" {
+		c.Location = config.New(ctx, "gcp").Get("zone")
+	}
+	if c.Location == "" {
+		c.Location = c.Region
+	}
+	if len(c.Locations) == 0 && c.Location != "" {
+		c.Locations = []string{c.Location}
+	}
+
+	if c.GCPProject == "" {
+		c.GCPProject = config.New(ctx, "gcp").Get("project")
+	}
+	if c.SecretsBackend == "" {
+		c.SecretsBackend = c.defaultSecretsBackend()
+	}
+	c.Vault = c.Vault.withDefaults()
+	for i, secret := range c.Secrets {
+		c.Secrets[i] = secret.withDefaults()
+	}
+
+	// Without a whitelist there is nothing to open the default rule sets to
+	if len(c.FirewallRules) == 0 && c.WhitelistIp != "" {
+		c.FirewallRules = slices.Clone(defaultFirewallRuleSets)
+	}
+	for i, ruleSet := range c.FirewallRules {
+		c.FirewallRules[i] = ruleSet.withDefaults(c.WhitelistIp)
+	}
+
+	if c.Environment == "" {
+		c.Environment = "dev"
+	}
+
+	if c.IngressLoadBalancer == "" {
+		c.IngressLoadBalancer = "external"
+	}
+	if c.DNSDomain == "" {
+		c.DNSDomain = "dimo.zone"
+	}
+	if c.GrafanaHost == "" {
+		c.GrafanaHost = "monitoring.driveomid.xyz"
+	}
+
+	if c.GKEMasterIpv4Cidr == "" {
+		c.GKEMasterIpv4Cidr = "172.16.0.0/28"
+	}
+	c.GKEDefaultPool = c.GKEDefaultPool.withDefaults(3)
+	if len(c.GKENodePools) == 0 {
+		c.GKENodePools = []GKENodePool{defaultGKENodePool}
+	}
+	for i, pool := range c.GKENodePools {
+		c.GKENodePools[i] = pool.withDefaults(1)
+	}
+
+	c.K3sHost = c.K3sHost.withDefaults()
+
+	c.EKSDefaultNodeGroup = c.EKSDefaultNodeGroup.withDefaults()
+	if len(c.EKSNodeGroups) == 0 {
+		c.EKSNodeGroups = slices.Clone(defaultEKSNodeGroups)
+	}
+	for i, group := range c.EKSNodeGroups {
+		c.EKSNodeGroups[i] = group.withDefaults()
+	}
+
+	c.AKSSystemPool = c.AKSSystemPool.withDefaults()
+	c.AKSUserPool = c.AKSUserPool.withDefaults()
+}
+
+func (c *NodeConfig) validate(deploymentTypes []string) (problems []string) {
+	if c.CloudProvider == "" {
+		problems = append(problems, "cloud-provider is required")
+	} else if !slices.Contains(cloudProviders, c.CloudProvider) {
+		problems = append(problems, fmt.Sprintf("cloud-provider %s not supported (available: %s)", c.CloudProvider, strings.Join(cloudProviders, ", ")))
+	}
+
+	if c.DeploymentType == "" {
+		problems = append(problems, "deployment-type is required")
+	} else if !slices.Contains(deploymentTypes, c.DeploymentType) {
+		problems = append(problems, fmt.Sprintf("deployment-type %s not supported (available: %s)", c.DeploymentType, strings.Join(deploymentTypes, ", ")))
+	}
+	if clouds, exists := deploymentTypeClouds[c.DeploymentType]; exists && slices.Contains(cloudProviders, c.CloudProvider) && !slices.Contains(clouds, c.CloudProvider) {
+		problems = append(problems, fmt.Sprintf("deployment-type %s requires cloud-provider %s, got %s", c.DeploymentType, strings.Join(clouds, " or "), c.CloudProvider))
+	}
+
+	// Clusters we build in a cloud need to know where to put them
+	buildsCloudCluster := c.DeploymentType != "existing" && c.CloudProvider != "local"
+	if buildsCloudCluster && c.Region == "" {
+		problems = append(problems, "region is required")
+	}
+	if buildsCloudCluster && c.Region != "" {
+		if !zoneInRegion(c.CloudProvider, c.Location, c.Region) {
+			problems = append(problems, fmt.Sprintf("location %s is not in region %s", c.Location, c.Region))
+		}
+		for _, location := range c.Locations {
+			if !zoneInRegion(c.CloudProvider, location, c.Region) {
+				problems = append(problems, fmt.Sprintf("locations entry %s is not in region %s", location, c.Region))
+			}
+		}
+	}
+
+	if buildsCloudCluster && c.CloudProvider == "gcp" && len(c.FirewallRules) == 0 {
+		problems = append(problems, "whitelist-ip or firewall-rules is required to open the firewall on gcp")
+	}
+	if c.WhitelistIp != "" {
+		if _, _, err := net.ParseCIDR(c.WhitelistIp); err != nil {
+			problems = append(problems, fmt.Sprintf("whitelist-ip %s is not a valid CIDR (ex: 24.30.56.126/32)", c.WhitelistIp))
+		}
+	}
+
+	problems = append(problems, c.validateIngress()...)
+	problems = append(problems, c.validateDNS()...)
+	problems = append(problems, c.validateSecrets()...)
+
+	if c.UpgradeSettings != nil {
+		problems = append(problems, c.UpgradeSettings.validate("upgrade-settings", c.DeploymentType)...)
+	}
+	if c.MaintenanceWindow != nil {
+		if c.DeploymentType != "gke" && c.DeploymentType != "gke-autopilot" {
+			problems = append(problems, "maintenance-window is only supported on gke and gke-autopilot, since eks and k3s only upgrade when their version changes")
+		}
+		problems = append(problems, c.MaintenanceWindow.validate("maintenance-window")...)
+	}
+
+	ruleSetNames := map[string]bool{}
+	for _, ruleSet := range c.FirewallRules {
+		problems = append(problems, ruleSet.validate("firewall-rules")...)
+		if ruleSetNames[ruleSet.Name] {
+			problems = append(problems, fmt.Sprintf("firewall-rules name %s is used more than once", ruleSet.Name))
+		}
+		ruleSetNames[ruleSet.Name] = true
+	}
+
+	// Standard and Autopilot clusters share the version and control plane settings
+	if c.DeploymentType == "gke" || c.DeploymentType == "gke-autopilot" {
+		if c.GKEVersion != "" && !gkeVersionPattern.MatchString(c.GKEVersion) {
+			problems = append(problems, fmt.Sprintf("gke-version %s must be a GKE version (ex: 1.30 or 1.30.5-gke.1014001)", c.GKEVersion))
+		}
+		if c.GKEPrivateEndpoint && !c.GKEPrivateCluster {
+			problems = append(problems, "gke-private-endpoint requires gke-private-cluster")
+		}
+		if _, masterNet, err := net.ParseCIDR(c.GKEMasterIpv4Cidr); err != nil {
+			problems = append(problems, fmt.Sprintf("gke-master-ipv4-cidr %s is not a valid CIDR", c.GKEMasterIpv4Cidr))
+		} else if ones, _ := masterNet.Mask.Size(); ones != 28 {
+			problems = append(problems, fmt.Sprintf("gke-master-ipv4-cidr %s must be a /28", c.GKEMasterIpv4Cidr))
+		}
+		for _, cidr := range c.MasterAuthorizedCidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				problems = append(problems, fmt.Sprintf("master-authorized-networks entry %s is not a valid CIDR", cidr))
+			}
+		}
+	}
+
+	if c.DeploymentType == "gke" {
+		problems = append(problems, c.GKEDefaultPool.validate("gke-default-pool", c.CloudProvider, c.Region)...)
+		if c.GKEDefaultPool.MinNodes < 1 {
+			problems = append(problems, "gke-default-pool minNodes must be at least 1, GKE needs a node to create the cluster")
+		}
+		if c.GKERemoveDefaultPool && !c.CreateNodePools {
+			problems = append(problems, "gke-remove-default-pool leaves the cluster without nodes unless create-node-pools is set")
+		}
+
+		poolNames := map[string]bool{}
+		for _, pool := range c.GKENodePools {
+			problems = append(problems, pool.validate("gke-node-pools", c.CloudProvider, c.Region)...)
+			if poolNames[pool.Name] {
+				problems = append(problems, fmt.Sprintf("gke-node-pools name %s is used more than once", pool.Name))
+			}
+			poolNames[pool.Name] = true
+		}
+
+		autoscaling := c.GKEClusterAutoscaling
+		if autoscaling.Enabled && (autoscaling.MinCpu > autoscaling.MaxCpu || autoscaling.MinMemoryGb > autoscaling.MaxMemoryGb) {
+			problems = append(problems, "gke-cluster-autoscaling minimums cannot be above the maximums")
+		}
+	}
+
+	if c.DeploymentType == "gke-autopilot" {
+		if c.CreateNodePools {
+			problems = append(problems, "create-node-pools is not supported on gke-autopilot, Google manages the nodes")
+		}
+	}
+
+	if c.DeploymentType == "eks" {
+		if c.EKSVersion != "" && !eksVersionPattern.MatchString(c.EKSVersion) {
+			problems = append(problems, fmt.Sprintf("eks-version %s must be a major.minor version (ex: 1.30)", c.EKSVersion))
+		}
+		problems = append(problems, c.EKSDefaultNodeGroup.validate("eks-default-node-group")...)
+
+		// The default group keeps its own name, so it can't clash with the prefixed create-node-pools groups
+		groupNames := map[string]bool{}
+		for _, group := range c.EKSNodeGroups {
+			problems = append(problems, group.validate("eks-node-groups")...)
+			if groupNames[group.Name] {
+				problems = append(problems, fmt.Sprintf("eks-node-groups name %s is used more than once", group.Name))
+			}
+			groupNames[group.Name] = true
+		}
+	}
+
+	if c.DeploymentType == "aks" {
+		// AKS won't create a cluster without a node for its system pods
+		problems = append(problems, c.AKSSystemPool.validate("aks-system-pool", 1)...)
+		problems = append(problems, c.AKSUserPool.validate("aks-user-pool", 0)...)
+	}
+
+	if c.DeploymentType == "k3s" {
+		if c.K3sServers < 1 {
+			problems = append(problems, fmt.Sprintf("k3s-servers must be at least 1, got %d", c.K3sServers))
+		}
+		if c.K3sAgents < 0 {
+			problems = append(problems, fmt.Sprintf("k3s-agents cannot be negative, got %d", c.K3sAgents))
+		}
+		if c.K3sServers > 1 && !c.K3sHA {
+			problems = append(problems, fmt.Sprintf("k3s-servers is %d but more than one server requires k3s-ha to be enabled", c.K3sServers))
+		}
+		if c.K3sHA && c.K3sServers%2 == 0 {
+			problems = append(problems, fmt.Sprintf("k3s-servers must be an odd number for embedded etcd quorum, got %d", c.K3sServers))
+		}
+		problems = append(problems, c.K3sHost.validate("k3s-host")...)
+		// The version ends up in the key's resource name
+		if c.SSHKeyVersion != "" && !sshKeyVersionPattern.MatchString(c.SSHKeyVersion) {
+			problems = append(problems, fmt.Sprintf("ssh-key-version %q must be lowercase letters, numbers and dashes (ex: 2 or 2026-10)", c.SSHKeyVersion))
+		}
+	}
+
+	if c.DeploymentType == "kind" || c.DeploymentType == "k3d" {
+		for key, port := range map[string]int{
+			"local-registry-port": c.LocalRegistryPort,
+			"local-http-port":     c.LocalHttpPort,
+			"local-https-port":    c.LocalHttpsPort,
+		} {
+			if port < 1 || port > 65535 {
+				problems = append(problems, fmt.Sprintf("%s must be between 1 and 65535, got %d", key, port))
+			}
+		}
+	}
+
+	// Sort so the report doesn't shuffle between runs
+	slices.Sort(problems)
+
+	return problems
+}
+
+// parseLocations accepts both a YAML list (which pulumi hands back as JSON) and a comma separated string
+func parseLocations(value string) ([]string, error) {
+	var locations []string
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		if err := json.Unmarshal([]byte(value), &locations); err != nil {
+			return nil, err
+		}
+	} else {
+		locations = strings.Split(value, ",")
+	}
+
+	var cleaned []string
+	for _, location := range locations {
+		location = strings.TrimSpace(location)
+		if location != "" {
+			cleaned = append(cleaned, location)
+		}
+	}
+
+	return cleaned, nil
+}
+
+// zoneInRegion reports whether location is the region itself or one of its zones.
+// GCP zones are <region>-<letter> and AWS zones are <region><letter>, Azure zones are just numbers.
+func zoneInRegion(cloudProvider string, location string, region string) bool {
+	if location == region {
+		return true
+	}
+
+	switch cloudProvider {
+	case "gcp":
+		zone, found := strings.CutPrefix(location, region+"-")
+		return found && len(zone) == 1
+	case "aws":
+		zone, found := strings.CutPrefix(location, region)
+		return found && len(zone) == 1
+	case "azure":
+		return location == "1" || location == "2" || location == "3"
+	}
+
+	return true
+}
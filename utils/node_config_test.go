@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateK3s(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers int
+		agents  int
+		ha      bool
+		host    K3sHostConfig
+		want    []string
+	}{
+		{name: "single server", servers: 1},
+		{name: "single server with agents", servers: 1, agents: 2},
+		{name: "HA with three servers", servers: 3, ha: true},
+		{name: "HA with a single server", servers: 1, ha: true},
+		{
+			name:    "no servers",
+			servers: 0,
+			want:    []string{"k3s-servers must be at least 1, got 0"},
+		},
+		{
+			name:    "negative agents",
+			servers: 1,
+			agents:  -1,
+			want:    []string{"k3s-agents cannot be negative, got -1"},
+		},
+		{
+			name:    "several servers without HA",
+			servers: 3,
+			want:    []string{"k3s-servers is 3 but more than one server requires k3s-ha to be enabled"},
+		},
+		{
+			name:    "HA with an even server count",
+			servers: 2,
+			ha:      true,
+			want:    []string{"k3s-servers must be an odd number for embedded etcd quorum, got 2"},
+		},
+		{
+			name:    "host version and flags",
+			servers: 1,
+			host:    K3sHostConfig{Version: "1.30", ServerFlags: []string{"disable=traefik"}},
+			want: []string{
+				`k3s-host flag "disable=traefik" must start with --`,
+				"k3s-host version 1.30 must be a k3s release (ex: v1.30.4+k3s1)",
+			},
+		},
+		{
+			name:    "upgrade controller without a version",
+			servers: 1,
+			host:    K3sHostConfig{UpgradeController: true},
+			want:    []string{"k3s-host upgradeController needs a pinned version to upgrade to"},
+		},
+		{
+			name:    "host packages and sysctls",
+			servers: 1,
+			host:    K3sHostConfig{Packages: []string{"jq; reboot"}, Sysctl: map[string]string{"vm.max_map_count": "$(reboot)"}},
+			want: []string{
+				`k3s-host package "jq; reboot" is not a valid apt package name`,
+				`k3s-host sysctl vm.max_map_count value "$(reboot)" cannot be empty or contain quotes, newlines or shell characters`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &NodeConfig{
+				CloudProvider:  "gcp",
+				DeploymentType: "k3s",
+				Region:         "us-central1",
+				K3sServers:     test.servers,
+				K3sAgents:      test.agents,
+				K3sHA:          test.ha,
+				K3sHost:        test.host.withDefaults(),
+			}
+
+			// Only the k3s problems, the rest of the config is left empty on purpose
+			var got []string
+			for _, problem := range c.validate([]string{"k3s"}) {
+				if strings.HasPrefix(problem, "k3s-") {
+					got = append(got, problem)
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("validate() k3s problems\n got: %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateDeploymentTypeCloud(t *testing.T) {
+	deploymentTypes := []string{"gke", "gke-autopilot", "eks", "aks", "k3s", "kind", "k3d", "existing"}
+	tests := []struct {
+		cloudProvider  string
+		deploymentType string
+		want           string
+	}{
+		{cloudProvider: "aws", deploymentType: "eks"},
+		{cloudProvider: "gcp", deploymentType: "k3s"},
+		{cloudProvider: "aws", deploymentType: "k3s"},
+		{cloudProvider: "local", deploymentType: "existing"},
+		{cloudProvider: "gcp", deploymentType: "eks", want: "deployment-type eks requires cloud-provider aws, got gcp"},
+		{cloudProvider: "azure", deploymentType: "k3s", want: "deployment-type k3s requires cloud-provider gcp or aws, got azure"},
+		{cloudProvider: "aws", deploymentType: "kind", want: "deployment-type kind requires cloud-provider local, got aws"},
+		{cloudProvider: "aws", deploymentType: "gke", want: "deployment-type gke requires cloud-provider gcp, got aws"},
+		{cloudProvider: "aws", deploymentType: "gke-autopilot", want: "deployment-type gke-autopilot requires cloud-provider gcp, got aws"},
+		{cloudProvider: "gcp", deploymentType: "aks", want: "deployment-type aks requires cloud-provider azure, got gcp"},
+		{cloudProvider: "local", deploymentType: "k3d"},
+		// An unknown cloud provider is reported on its own, not as a mismatch too
+		{cloudProvider: "digitalocean", deploymentType: "eks"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.deploymentType+" on "+test.cloudProvider, func(t *testing.T) {
+			c := &NodeConfig{CloudProvider: test.cloudProvider, DeploymentType: test.deploymentType}
+
+			var got []string
+			for _, problem := range c.validate(deploymentTypes) {
+				if strings.HasPrefix(problem, "deployment-type ") {
+					got = append(got, problem)
+				}
+			}
+			var want []string
+			if test.want != "" {
+				want = []string{test.want}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("validate() deployment-type problems\n got: %q\nwant: %q", got, want)
+			}
+		})
+	}
+}
+
+// problemsWithPrefix validates c and keeps the problems about the config keys the test covers
+func problemsWithPrefix(c *NodeConfig, deploymentTypes []string, prefixes ...string) []string {
+	var problems []string
+	for _, problem := range c.validate(deploymentTypes) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(problem, prefix) {
+				problems = append(problems, problem)
+				break
+			}
+		}
+	}
+	return problems
+}
+
+func TestZoneInRegion(t *testing.T) {
+	tests := []struct {
+		cloudProvider string
+		location      string
+		region        string
+		want          bool
+	}{
+		{cloudProvider: "gcp", location: "us-central1", region: "us-central1", want: true},
+		{cloudProvider: "gcp", location: "us-central1-a", region: "us-central1", want: true},
+		{cloudProvider: "gcp", location: "us-east1-b", region: "us-central1", want: false},
+		{cloudProvider: "gcp", location: "us-central1a", region: "us-central1", want: false},
+		{cloudProvider: "gcp", location: "us-central1-ab", region: "us-central1", want: false},
+		{cloudProvider: "aws", location: "us-east-1a", region: "us-east-1", want: true},
+		{cloudProvider: "aws", location: "us-east-1-a", region: "us-east-1", want: false},
+		{cloudProvider: "aws", location: "us-west-2a", region: "us-east-1", want: false},
+		{cloudProvider: "azure", location: "2", region: "eastus", want: true},
+		{cloudProvider: "azure", location: "4", region: "eastus", want: false},
+		{cloudProvider: "azure", location: "eastus", region: "eastus", want: true},
+		{cloudProvider: "local", location: "anywhere", region: "", want: true},
+	}
+
+	for _, test := range tests {
+		if got := zoneInRegion(test.cloudProvider, test.location, test.region); got != test.want {
+			t.Errorf("zoneInRegion(%s, %s, %s) = %t, want %t", test.cloudProvider, test.location, test.region, got, test.want)
+		}
+	}
+}
+
+func TestParseLocations(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "unset", value: ""},
+		{name: "comma separated", value: "us-central1-a, us-central1-b,,us-central1-c", want: []string{"us-central1-a", "us-central1-b", "us-central1-c"}},
+		{name: "single", value: "us-east-1a", want: []string{"us-east-1a"}},
+		{name: "yaml list", value: `["us-central1-a", " us-central1-b "]`, want: []string{"us-central1-a", "us-central1-b"}},
+		{name: "broken yaml list", value: `["us-central1-a"`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseLocations(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseLocations(%q) error = %v, wantErr %t", test.value, err, test.wantErr)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("parseLocations(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateRegion(t *testing.T) {
+	tests := []struct {
+		name           string
+		cloudProvider  string
+		deploymentType string
+		region         string
+		location       string
+		locations      []string
+		want           []string
+	}{
+		{name: "gcp zone", cloudProvider: "gcp", deploymentType: "gke", region: "us-central1", location: "us-central1-a", locations: []string{"us-central1-b", "us-central1-c"}},
+		{name: "gcp regional", cloudProvider: "gcp", deploymentType: "gke", region: "us-central1", location: "us-central1"},
+		{name: "aws zone", cloudProvider: "aws", deploymentType: "eks", region: "us-east-1", location: "us-east-1a"},
+		{
+			name:          "missing region",
+			cloudProvider: "gcp", deploymentType: "gke",
+			want: []string{"region is required"},
+		},
+		{
+			name:          "location in another region",
+			cloudProvider: "gcp", deploymentType: "gke", region: "us-central1", location: "us-east1-b",
+			want: []string{"location us-east1-b is not in region us-central1"},
+		},
+		{
+			name:          "locations entry in another region",
+			cloudProvider: "aws", deploymentType: "eks", region: "us-east-1", location: "us-east-1a", locations: []string{"us-east-1b", "us-west-2a"},
+			want: []string{"locations entry us-west-2a is not in region us-east-1"},
+		},
+		// Neither builds a cluster in a cloud, so there is nothing to place
+		{name: "existing cluster", cloudProvider: "gcp", deploymentType: "existing"},
+		{name: "local cluster", cloudProvider: "local", deploymentType: "kind"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &NodeConfig{
+				CloudProvider:  test.cloudProvider,
+				DeploymentType: test.deploymentType,
+				Region:         test.region,
+				Location:       test.location,
+				Locations:      test.locations,
+			}
+
+			got := problemsWithPrefix(c, []string{test.deploymentType}, "region ", "location ", "locations ")
+			if !slices.Equal(got, test.want) {
+				t.Errorf("validate() region problems\n got: %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateCIDRs(t *testing.T) {
+	tests := []struct {
+		name                  string
+		whitelistIp           string
+		masterIpv4Cidr        string
+		masterAuthorizedCidrs []string
+		want                  []string
+	}{
+		{name: "valid", whitelistIp: "24.30.56.126/32", masterIpv4Cidr: "172.16.0.0/28", masterAuthorizedCidrs: []string{"10.0.0.0/8"}},
+		{
+			name:        "whitelist-ip without a prefix length",
+			whitelistIp: "24.30.56.126", masterIpv4Cidr: "172.16.0.0/28",
+			want: []string{"whitelist-ip 24.30.56.126 is not a valid CIDR (ex: 24.30.56.126/32)"},
+		},
+		{
+			name:           "master range is not a CIDR",
+			masterIpv4Cidr: "172.16.0.0",
+			want:           []string{"gke-master-ipv4-cidr 172.16.0.0 is not a valid CIDR"},
+		},
+		{
+			name:           "master range is not a /28",
+			masterIpv4Cidr: "172.16.0.0/24",
+			want:           []string{"gke-master-ipv4-cidr 172.16.0.0/24 must be a /28"},
+		},
+		{
+			name:           "authorized network is not a CIDR",
+			masterIpv4Cidr: "172.16.0.0/28", masterAuthorizedCidrs: []string{"10.0.0.0/8", "office"},
+			want: []string{"master-authorized-networks entry office is not a valid CIDR"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &NodeConfig{
+				CloudProvider:         "gcp",
+				DeploymentType:        "gke",
+				Region:                "us-central1",
+				Location:              "us-central1-a",
+				WhitelistIp:           test.whitelistIp,
+				FirewallRules:         []FirewallRuleSet{{Name: "ssh"}}, // Keeps the missing whitelist-ip problem out
+				GKEMasterIpv4Cidr:     test.masterIpv4Cidr,
+				MasterAuthorizedCidrs: test.masterAuthorizedCidrs,
+			}
+
+			got := problemsWithPrefix(c, []string{"gke"}, "whitelist-ip ", "gke-master-ipv4-cidr ", "master-authorized-networks ")
+			if !slices.Equal(got, test.want) {
+				t.Errorf("validate() CIDR problems\n got: %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var nodePoolNamePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+var eksVersionPattern = regexp.MustCompile(`^\d+\.\d+$`)
+var aksVmSizePattern = regexp.MustCompile(`^Standard_[A-Za-z0-9_]+$`)
+
+// taintEffects maps the Kubernetes taint effects users write in config to the GKE/EKS API values
+var taintEffects = map[string]string{
+	"NoSchedule":       "NO_SCHEDULE",
+	"PreferNoSchedule": "PREFER_NO_SCHEDULE",
+	"NoExecute":        "NO_EXECUTE",
+}
+
+// NodeTaint is a Kubernetes taint applied to every node in a pool
+type NodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Effect string `json:"effect"` // NoSchedule, PreferNoSchedule or NoExecute
+}
+
+// APIEffect returns the effect in the upper snake case the GKE and EKS APIs expect
+func (t NodeTaint) APIEffect() string {
+	return taintEffects[t.Effect]
+}
+
+// GKENodePool is one entry of the gke-node-pools config list
+type GKENodePool struct {
+	Name        string            `json:"name"`
+	MachineType string            `json:"machineType"` // Defaults to n1-standard-1
+	DiskType    string            `json:"diskType"`    // pd-standard, pd-balanced or pd-ssd, GKE picks when unset
+	DiskSizeGb  int               `json:"diskSizeGb"`  // Defaults to 30
+	MinNodes    int               `json:"minNodes"`    // Per zone, the pool autoscales when maxNodes is higher
+	MaxNodes    int               `json:"maxNodes"`
+	Spot        bool              `json:"spot"`
+	Preemptible bool              `json:"preemptible"`
+	Labels      map[string]string `json:"labels"`
+	Taints      []NodeTaint       `json:"taints"`
+	Zones       []string          `json:"zones"` // Defaults to locations
+}
+
+// Autoscaling reports whether the pool has room to scale rather than a fixed node count
+func (p GKENodePool) Autoscaling() bool {
+	return p.MaxNodes > p.MinNodes
+}
+
+// GKEClusterAutoscaling bounds the node auto-provisioning GKE does on top of the node pools
+type GKEClusterAutoscaling struct {
+	Enabled     bool `json:"enabled"`
+	MinCpu      int  `json:"minCpu"`
+	MaxCpu      int  `json:"maxCpu"`
+	MinMemoryGb int  `json:"minMemoryGb"`
+	MaxMemoryGb int  `json:"maxMemoryGb"`
+}
+
+// defaultGKENodePool is the pool create-node-pools has always built, used when gke-node-pools is not set
+var defaultGKENodePool = GKENodePool{
+	Name:     "small",
+	MinNodes: 1,
+	MaxNodes: 1,
+}
+
+// defaultGKEClusterAutoscaling matches the limits the cluster was originally created with
+var defaultGKEClusterAutoscaling = GKEClusterAutoscaling{
+	Enabled:     true,
+	MinCpu:      1,
+	MaxCpu:      10,
+	MinMemoryGb: 1,
+	MaxMemoryGb: 64,
+}
+
+// withDefaults fills in the machine shape and a node count of nodes when none is given
+func (p GKENodePool) withDefaults(nodes int) GKENodePool {
+	if p.MachineType == "" {
+		p.MachineType = "n1-standard-1"
+	}
+	if p.DiskSizeGb == 0 {
+		p.DiskSizeGb = 30
+	}
+	if p.MinNodes == 0 && p.MaxNodes == 0 {
+		p.MinNodes = nodes
+	}
+	if p.MaxNodes < p.MinNodes {
+		p.MaxNodes = p.MinNodes
+	}
+	return p
+}
+
+// validate checks one pool, key is the config key it came from for the error messages
+func (p GKENodePool) validate(key string, cloudProvider string, region string) (problems []string) {
+	if !nodePoolNamePattern.MatchString(p.Name) {
+		problems = append(problems, fmt.Sprintf("%s name %q must be lowercase letters, numbers and dashes", key, p.Name))
+	}
+	if p.DiskType != "" && !slices.Contains([]string{"pd-standard", "pd-balanced", "pd-ssd"}, p.DiskType) {
+		problems = append(problems, fmt.Sprintf("%s %s diskType %s must be pd-standard, pd-balanced or pd-ssd", key, p.Name, p.DiskType))
+	}
+	if p.DiskSizeGb < 10 {
+		problems = append(problems, fmt.Sprintf("%s %s diskSizeGb must be at least 10, got %d", key, p.Name, p.DiskSizeGb))
+	}
+	if p.MinNodes < 0 {
+		problems = append(problems, fmt.Sprintf("%s %s minNodes cannot be negative, got %d", key, p.Name, p.MinNodes))
+	}
+	if p.Spot && p.Preemptible {
+		problems = append(problems, fmt.Sprintf("%s %s can be spot or preemptible, not both", key, p.Name))
+	}
+	problems = append(problems, validateTaints(fmt.Sprintf("%s %s", key, p.Name), p.Taints)...)
+	for _, zone := range p.Zones {
+		if !zoneInRegion(cloudProvider, zone, region) {
+			problems = append(problems, fmt.Sprintf("%s %s zone %s is not in region %s", key, p.Name, zone, region))
+		}
+	}
+
+	return problems
+}
+
+func validateTaints(owner string, taints []NodeTaint) (problems []string) {
+	effects := make([]string, 0, len(taintEffects))
+	for effect := range taintEffects {
+		effects = append(effects, effect)
+	}
+	slices.Sort(effects)
+
+	for _, taint := range taints {
+		if taint.Key == "" {
+			problems = append(problems, fmt.Sprintf("%s has a taint without a key", owner))
+		}
+		if _, ok := taintEffects[taint.Effect]; !ok {
+			problems = append(problems, fmt.Sprintf("%s taint %s effect %q must be one of %s", owner, taint.Key, taint.Effect, strings.Join(effects, ", ")))
+		}
+	}
+
+	return problems
+}
+
+// EKSNodeGroup is one entry of the eks-node-groups config list.
+// Optional fields are only sent to AWS when set, most of them replace the node group when changed.
+type EKSNodeGroup struct {
+	Name          string            `json:"name"`
+	InstanceTypes []string          `json:"instanceTypes"` // EKS picks t3.medium when unset
+	CapacityType  string            `json:"capacityType"`  // ON_DEMAND or SPOT, EKS defaults to ON_DEMAND
+	AmiType       string            `json:"amiType"`       // ex: AL2_x86_64, AL2023_x86_64_STANDARD, BOTTLEROCKET_x86_64
+	DiskSizeGb    int               `json:"diskSizeGb"`    // EKS defaults to 20
+	MinSize       int               `json:"minSize"`
+	MaxSize       int               `json:"maxSize"`     // Defaults to the larger of minSize and desiredSize
+	DesiredSize   int               `json:"desiredSize"` // Defaults to minSize
+	Labels        map[string]string `json:"labels"`
+	Taints        []NodeTaint       `json:"taints"`
+}
+
+// defaultEKSNodeGroup is the node group the cluster has always been created with
+var defaultEKSNodeGroup = EKSNodeGroup{
+	Name:        "node-group",
+	MinSize:     3,
+	MaxSize:     6,
+	DesiredSize: 3,
+}
+
+// defaultEKSNodeGroups are the groups create-node-pools has always built, used when eks-node-groups is not set
+var defaultEKSNodeGroups = []EKSNodeGroup{
+	{Name: "small", MinSize: 1, MaxSize: 5, DesiredSize: 3},
+	{Name: "medium", MinSize: 1, MaxSize: 5, DesiredSize: 3},
+}
+
+func (g EKSNodeGroup) withDefaults() EKSNodeGroup {
+	if g.DesiredSize < g.MinSize {
+		g.DesiredSize = g.MinSize
+	}
+	if g.MaxSize == 0 {
+		g.MaxSize = max(g.DesiredSize, 1)
+	}
+	return g
+}
+
+// validate checks one node group, key is the config key it came from for the error messages
+func (g EKSNodeGroup) validate(key string) (problems []string) {
+	if !nodePoolNamePattern.MatchString(g.Name) {
+		problems = append(problems, fmt.Sprintf("%s name %q must be lowercase letters, numbers and dashes", key, g.Name))
+	}
+	if g.CapacityType != "" && g.CapacityType != "ON_DEMAND" && g.CapacityType != "SPOT" {
+		problems = append(problems, fmt.Sprintf("%s %s capacityType %s must be ON_DEMAND or SPOT", key, g.Name, g.CapacityType))
+	}
+	if g.DiskSizeGb != 0 && g.DiskSizeGb < 4 {
+		problems = append(problems, fmt.Sprintf("%s %s diskSizeGb must be at least 4, got %d", key, g.Name, g.DiskSizeGb))
+	}
+	if g.MinSize < 0 || g.MaxSize < 1 || g.MinSize > g.MaxSize || g.DesiredSize > g.MaxSize {
+		problems = append(problems, fmt.Sprintf("%s %s sizes must satisfy 0 <= minSize <= desiredSize <= maxSize and maxSize >= 1, got %d/%d/%d", key, g.Name, g.MinSize, g.DesiredSize, g.MaxSize))
+	}
+	problems = append(problems, validateTaints(fmt.Sprintf("%s %s", key, g.Name), g.Taints)...)
+
+	return problems
+}
+
+// AKSNodePool is the aks-system-pool or aks-user-pool config object
+type AKSNodePool struct {
+	VmSize     string `json:"vmSize"`     // Defaults to Standard_D2s_v3
+	DiskSizeGb int    `json:"diskSizeGb"` // Defaults to 30
+	MinNodes   int    `json:"minNodes"`   // The pool autoscales when maxNodes is higher
+	MaxNodes   int    `json:"maxNodes"`
+}
+
+// defaultAKSSystemPool and defaultAKSUserPool are the pools the cluster has always been created with
+var defaultAKSSystemPool = AKSNodePool{MinNodes: 1}
+var defaultAKSUserPool = AKSNodePool{MinNodes: 1, MaxNodes: 3}
+
+func (p AKSNodePool) withDefaults() AKSNodePool {
+	if p.VmSize == "" {
+		p.VmSize = "Standard_D2s_v3"
+	}
+	if p.DiskSizeGb == 0 {
+		p.DiskSizeGb = 30
+	}
+	if p.MaxNodes < p.MinNodes {
+		p.MaxNodes = p.MinNodes
+	}
+	return p
+}
+
+// Autoscaling reports whether the pool has room to scale rather than a fixed node count
+func (p AKSNodePool) Autoscaling() bool {
+	return p.MaxNodes > p.MinNodes
+}
+
+// validate checks one pool, key is the config key it came from for the error messages
+func (p AKSNodePool) validate(key string, minNodes int) (problems []string) {
+	if !aksVmSizePattern.MatchString(p.VmSize) {
+		problems = append(problems, fmt.Sprintf("%s vmSize %s must be an Azure VM size (ex: Standard_D2s_v3)", key, p.VmSize))
+	}
+	if p.DiskSizeGb < 30 {
+		problems = append(problems, fmt.Sprintf("%s diskSizeGb must be at least 30, got %d", key, p.DiskSizeGb))
+	}
+	if p.MinNodes < minNodes {
+		problems = append(problems, fmt.Sprintf("%s minNodes must be at least %d, got %d", key, minNodes, p.MinNodes))
+	}
+
+	return problems
+}
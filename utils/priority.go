@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Priority tiers, every deployment type creates the same three classes (infrastructure.CreatePriorityTiers)
+const (
+	// PriorityCritical is the platform everything else needs: ingress, certificates, secrets and DNS
+	PriorityCritical = "dimo-critical"
+	// PriorityCore is the DIMO applications, their database and monitoring
+	PriorityCore = "dimo-core"
+	// PriorityBatch is jobs, they never preempt anything and are the first to be preempted
+	PriorityBatch = "dimo-batch"
+)
+
+// PriorityTier returns a chart transformation that schedules the chart's pods with the tier.
+// Jobs in an application chart run as batch, and pods that already name a priority class keep it.
+func PriorityTier(tier string) yaml.Transformation {
+	return func(state map[string]interface{}, opts ...pulumi.ResourceOption) {
+		kind, _ := state["kind"].(string)
+		podTier := tier
+		if tier == PriorityCore && (kind == "Job" || kind == "CronJob") {
+			podTier = PriorityBatch
+		}
+
+		var podSpec map[string]interface{}
+		switch kind {
+		case "Pod":
+			podSpec = nestedMap(state, "spec")
+		case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+			podSpec = nestedMap(state, "spec", "template", "spec")
+		case "CronJob":
+			podSpec = nestedMap(state, "spec", "jobTemplate", "spec", "template", "spec")
+		}
+		if podSpec == nil {
+			return
+		}
+
+		if _, set := podSpec["priorityClassName"]; !set {
+			podSpec["priorityClassName"] = podTier
+		}
+	}
+}
+
+// PriorityTierManifests applies PriorityTier to a helm release's rendered manifests, for the post-renderer in
+// cmd/priority-tier. Releases are rendered by helm rather than pulumi, so chart transformations never see them.
+func PriorityTierManifests(manifests io.Reader, tier string) ([]byte, error) {
+	transform := PriorityTier(tier)
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(manifests))
+
+	var out bytes.Buffer
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var state map[string]interface{}
+		if err := sigsyaml.Unmarshal(document, &state); err != nil {
+			return nil, err
+		}
+		// Documents that are only comments come out empty
+		if len(state) == 0 {
+			continue
+		}
+
+		transform(state)
+		rendered, err := sigsyaml.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(rendered)
+	}
+
+	return out.Bytes(), nil
+}
+
+// nestedMap walks down a decoded manifest, nil if any level is missing
+func nestedMap(obj map[string]interface{}, keys ...string) map[string]interface{} {
+	for _, key := range keys {
+		next, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = next
+	}
+	return obj
+}
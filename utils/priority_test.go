@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPriorityTierManifests(t *testing.T) {
+	manifests := `# Source: identity-api/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: identity-api
+spec:
+  template:
+    spec:
+      containers:
+      - name: identity-api
+---
+# Source: identity-api/templates/migration.yaml
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: identity-api-migrations
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrations
+---
+# Source: identity-api/templates/worker.yaml
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: identity-api-worker
+spec:
+  template:
+    spec:
+      priorityClassName: dimo-critical
+---
+# Source: identity-api/templates/empty.yaml
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: identity-api
+`
+
+	rendered, err := PriorityTierManifests(strings.NewReader(manifests), PriorityCore)
+	if err != nil {
+		t.Fatalf("PriorityTierManifests: %v", err)
+	}
+
+	documents := strings.Split(strings.TrimPrefix(string(rendered), "---\n"), "---\n")
+	if len(documents) != 4 {
+		t.Fatalf("got %d documents, want 4 (the comment-only one is dropped):\n%s", len(documents), rendered)
+	}
+
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{kind: "Deployment", want: "priorityClassName: " + PriorityCore},
+		{kind: "Job", want: "priorityClassName: " + PriorityBatch},
+		// A class the chart already sets is kept
+		{kind: "StatefulSet", want: "priorityClassName: " + PriorityCritical},
+		{kind: "Service", want: ""},
+	}
+	for i, test := range tests {
+		document := documents[i]
+		if !strings.Contains(document, "kind: "+test.kind+"\n") {
+			t.Fatalf("document %d is not a %s:\n%s", i, test.kind, document)
+		}
+		if test.want == "" {
+			if strings.Contains(document, "priorityClassName") {
+				t.Errorf("%s was given a priority class:\n%s", test.kind, document)
+			}
+			continue
+		}
+		if !strings.Contains(document, test.want+"\n") {
+			t.Errorf("%s is missing %q:\n%s", test.kind, test.want, document)
+		}
+	}
+}
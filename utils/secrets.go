@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var secretsBackends = []string{"gcpsm", "aws", "vault", "fake"}
+
+var secretGenerators = []string{"random", "imported", "placeholder"}
+
+// Remote keys the applications' own ExternalSecrets read
+const (
+	IdentityAPISecretID   = "identity-api-secret"
+	DeviceDataAPISecretID = "device-data-api-secret"
+	DexAuthNSecretID      = "daas-secret"
+)
+
+var appSecretIDs = []string{IdentityAPISecretID, DeviceDataAPISecretID, DexAuthNSecretID}
+
+// SecretSpec declares a secret in the backend and the Kubernetes secrets it is synced into.
+// Pulumi creates the backend secret, so a fresh node doesn't sit with ExternalSecrets pointing at nothing.
+type SecretSpec struct {
+	Name      string           `json:"name"`      // Remote key in the backend
+	Generator string           `json:"generator"` // random, imported or placeholder
+	Length    int              `json:"length"`    // random only, defaults to 32
+	Special   bool             `json:"special"`   // random only, include special characters
+	Value     string           `json:"value"`     // imported only, set it as a secret with --path
+	Consumers []SecretConsumer `json:"consumers"`
+}
+
+// SecretConsumer is a Kubernetes secret kept in sync with the backend secret by an ExternalSecret
+type SecretConsumer struct {
+	Namespace  string `json:"namespace"`
+	SecretName string `json:"secretName"` // Defaults to the remote key
+	Key        string `json:"key"`        // Key in the Kubernetes secret, defaults to value
+}
+
+func (s SecretSpec) withDefaults() SecretSpec {
+	if s.Generator == "random" && s.Length == 0 {
+		s.Length = 32
+	}
+	for i, consumer := range s.Consumers {
+		if consumer.SecretName == "" {
+			s.Consumers[i].SecretName = s.Name
+		}
+		if consumer.Key == "" {
+			s.Consumers[i].Key = "value"
+		}
+	}
+	return s
+}
+
+func (s SecretSpec) validate(key string) (problems []string) {
+	if s.Name == "" {
+		return append(problems, fmt.Sprintf("%s entries need a name", key))
+	}
+	if !slices.Contains(secretGenerators, s.Generator) {
+		problems = append(problems, fmt.Sprintf("%s %s generator %q not supported (available: %s)", key, s.Name, s.Generator, strings.Join(secretGenerators, ", ")))
+	}
+	if s.Generator == "random" && s.Length < 8 {
+		problems = append(problems, fmt.Sprintf("%s %s length must be at least 8, got %d", key, s.Name, s.Length))
+	}
+	if s.Generator == "imported" && s.Value == "" {
+		problems = append(problems, fmt.Sprintf("%s %s is imported but has no value", key, s.Name))
+	}
+	if s.Generator != "imported" && s.Value != "" {
+		problems = append(problems, fmt.Sprintf("%s %s value is only used by the imported generator", key, s.Name))
+	}
+	for _, consumer := range s.Consumers {
+		if consumer.Namespace == "" {
+			problems = append(problems, fmt.Sprintf("%s %s consumers need a namespace", key, s.Name))
+		}
+	}
+	return problems
+}
+
+// VaultSettings points the ClusterSecretStore at a HashiCorp Vault KV engine.
+// external-secrets logs in with Vault's Kubernetes auth method as external-secrets-ksa.
+type VaultSettings struct {
+	Server    string `json:"server"`
+	Path      string `json:"path"`      // KV mount, defaults to secret
+	Version   string `json:"version"`   // KV engine version, v1 or v2, defaults to v2
+	MountPath string `json:"mountPath"` // Kubernetes auth mount, defaults to kubernetes
+	Role      string `json:"role"`      // Kubernetes auth role, defaults to external-secrets
+}
+
+func (v VaultSettings) withDefaults() VaultSettings {
+	if v.Path == "" {
+		v.Path = "secret"
+	}
+	if v.Version == "" {
+		v.Version = "v2"
+	}
+	if v.MountPath == "" {
+		v.MountPath = "kubernetes"
+	}
+	if v.Role == "" {
+		v.Role = "external-secrets"
+	}
+	return v
+}
+
+// defaultSecretsBackend keeps GCP on Secret Manager and moves EKS to Secrets Manager,
+// everything else has no backend it can reach without extra setup and gets the fake store
+func (c *NodeConfig) defaultSecretsBackend() string {
+	switch {
+	case c.CloudProvider == "gcp":
+		return "gcpsm"
+	case c.DeploymentType == "eks":
+		return "aws"
+	}
+	return "fake"
+}
+
+// SecretIDs are the backend secrets external-secrets reads: the applications' keys, password-configs and
+// the secrets manifest, sorted and without duplicates
+func (c *NodeConfig) SecretIDs() []string {
+	ids := slices.Clone(appSecretIDs)
+	for _, secret := range c.Secrets {
+		if !slices.Contains(ids, secret.Name) {
+			ids = append(ids, secret.Name)
+		}
+	}
+	for _, passwordConfig := range c.PasswordConfigs {
+		if passwordConfig.GCPSecretID != "" && !slices.Contains(ids, passwordConfig.GCPSecretID) {
+			ids = append(ids, passwordConfig.GCPSecretID)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+// validateSecrets checks the settings the chosen secrets backend needs
+func (c *NodeConfig) validateSecrets() (problems []string) {
+	if !slices.Contains(secretsBackends, c.SecretsBackend) {
+		return append(problems, fmt.Sprintf("secrets-backend %s not supported (available: %s)", c.SecretsBackend, strings.Join(secretsBackends, ", ")))
+	}
+
+	switch c.SecretsBackend {
+	case "gcpsm":
+		if c.GCPProject == "" {
+			problems = append(problems, "gcp-project is required for the gcpsm secrets backend")
+		}
+		if c.GCPWorkloadIdentity && c.ClusterName == "" {
+			problems = append(problems, "cluster-name is required when gcp-workload-identity is enabled")
+		}
+		if !c.GCPWorkloadIdentity && c.GCPCredentials == nil {
+			problems = append(problems, "gcp-credentials is required when gcp-workload-identity is disabled")
+		}
+	case "aws":
+		// IRSA needs the cluster's OIDC provider, which only the eks deployment type creates
+		if c.DeploymentType != "eks" {
+			problems = append(problems, "secrets-backend aws is only supported with deployment-type eks")
+		}
+	case "vault":
+		if c.Vault.Server == "" {
+			problems = append(problems, "vault server is required for the vault secrets backend")
+		}
+		if c.Vault.Version != "v1" && c.Vault.Version != "v2" {
+			problems = append(problems, fmt.Sprintf("vault version %s must be v1 or v2", c.Vault.Version))
+		}
+	}
+
+	if len(c.FakeSecrets) > 0 && c.SecretsBackend != "fake" {
+		problems = append(problems, "fake-secrets is only used by the fake secrets backend")
+	}
+
+	// The consumers' ExternalSecrets are named after their Kubernetes secret, so those have to be unique too
+	names := map[string]bool{}
+	consumers := map[string]bool{}
+	for _, secret := range c.Secrets {
+		problems = append(problems, secret.validate("secrets")...)
+		if names[secret.Name] {
+			problems = append(problems, fmt.Sprintf("secrets name %s is used more than once", secret.Name))
+		}
+		names[secret.Name] = true
+		if _, set := c.FakeSecrets[secret.Name]; set {
+			problems = append(problems, fmt.Sprintf("secrets %s is also set in fake-secrets", secret.Name))
+		}
+		for _, consumer := range secret.Consumers {
+			target := consumer.Namespace + "/" + consumer.SecretName
+			if consumers[target] {
+				problems = append(problems, fmt.Sprintf("secrets consumer %s is used more than once", target))
+			}
+			consumers[target] = true
+		}
+	}
+	// There is no Vault provider here to write with, Vault secrets are managed in Vault
+	if len(c.Secrets) > 0 && c.SecretsBackend == "vault" {
+		problems = append(problems, "secrets can't be created in the vault secrets backend")
+	}
+
+	return problems
+}
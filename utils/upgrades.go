@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var gkeVersionPattern = regexp.MustCompile(`^\d+\.\d+(\.\d+(-gke\.\d+)?)?$`)
+
+// UpgradeSettings is the upgrade-settings config object, how many nodes a pool upgrade adds or takes away at a time
+type UpgradeSettings struct {
+	MaxSurge       int `json:"maxSurge"` // GKE only, EKS can't add nodes during an upgrade
+	MaxUnavailable int `json:"maxUnavailable"`
+}
+
+// validate checks the settings against what the deployment type supports
+func (u UpgradeSettings) validate(key string, deploymentType string) (problems []string) {
+	if u.MaxSurge < 0 || u.MaxUnavailable < 0 {
+		problems = append(problems, fmt.Sprintf("%s maxSurge and maxUnavailable cannot be negative", key))
+	}
+
+	switch deploymentType {
+	case "gke":
+		if u.MaxSurge+u.MaxUnavailable == 0 {
+			problems = append(problems, fmt.Sprintf("%s needs maxSurge or maxUnavailable above 0 for an upgrade to make progress", key))
+		}
+	case "eks":
+		if u.MaxSurge != 0 {
+			problems = append(problems, fmt.Sprintf("%s maxSurge is not supported on eks", key))
+		}
+		if u.MaxUnavailable < 1 {
+			problems = append(problems, fmt.Sprintf("%s maxUnavailable must be at least 1 on eks", key))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s is only supported on gke and eks", key))
+	}
+
+	return problems
+}
+
+// MaintenanceWindow is the maintenance-window config object, when GKE may run automatic upgrades and repairs
+type MaintenanceWindow struct {
+	StartTime  string `json:"startTime"`  // RFC3339, sets the time of day the window opens (ex: 2024-01-06T03:00:00Z)
+	EndTime    string `json:"endTime"`    // RFC3339, sets how long the window stays open
+	Recurrence string `json:"recurrence"` // RFC5545 RRULE (ex: FREQ=WEEKLY;BYDAY=SA,SU)
+}
+
+func (w MaintenanceWindow) validate(key string) (problems []string) {
+	start, startErr := time.Parse(time.RFC3339, w.StartTime)
+	if startErr != nil {
+		problems = append(problems, fmt.Sprintf("%s startTime %q must be an RFC3339 time (ex: 2024-01-06T03:00:00Z)", key, w.StartTime))
+	}
+	end, endErr := time.Parse(time.RFC3339, w.EndTime)
+	if endErr != nil {
+		problems = append(problems, fmt.Sprintf("%s endTime %q must be an RFC3339 time (ex: 2024-01-06T07:00:00Z)", key, w.EndTime))
+	}
+	if startErr == nil && endErr == nil && !end.After(start) {
+		problems = append(problems, fmt.Sprintf("%s endTime must be after startTime", key))
+	}
+	if !strings.HasPrefix(w.Recurrence, "FREQ=") {
+		problems = append(problems, fmt.Sprintf("%s recurrence %q must be an RRULE (ex: FREQ=WEEKLY;BYDAY=SA,SU)", key, w.Recurrence))
+	}
+
+	return problems
+}
@@ -21,9 +21,27 @@ func ToPulumiStringArray(a []string) pulumi.StringArrayInput {
 	return pulumi.StringArray(res)
 }
 
+// PodResources is the requests block for a chart's pods. Every pod declares requests so the scheduler can place it,
+// and Autopilot, which sizes and bills nodes by requests, doesn't fall back to its large default.
+func PodResources(cpu string, memory string) pulumi.Map {
+	return pulumi.Map{
+		"requests": pulumi.Map{
+			"cpu":    pulumi.String(cpu),
+			"memory": pulumi.String(memory),
+		},
+	}
+}
+
+// Namespaces already created this run, so installs that share a namespace get the same resource back
+var createdNamespaces = map[string]*corev1.Namespace{}
+
 func CreateNamespaces(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, namespaces []string) (map[string]*corev1.Namespace, error) {
 	namespaceMap := make(map[string]*corev1.Namespace)
 	for _, namespace := range namespaces {
+		if ns, exists := createdNamespaces[namespace]; exists {
+			namespaceMap[namespace] = ns
+			continue
+		}
 		ns, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s", namespace), &corev1.NamespaceArgs{
 			Metadata: &metav1.ObjectMetaArgs{
 				Name: pulumi.String(namespace),
@@ -33,6 +51,7 @@ func CreateNamespaces(ctx *pulumi.Context, kubeProvider *kubernetes.Provider, na
 			return nil, err
 		}
 		namespaceMap[namespace] = ns
+		createdNamespaces[namespace] = ns
 	}
 
 	return namespaceMap, nil